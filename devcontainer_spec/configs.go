@@ -0,0 +1,108 @@
+package devcontainerspec
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// WorkspaceConfig is a devcontainer.json of a workspace. The default config has no name, the
+// configs in .devcontainer/<name>/devcontainer.json are named after their folder.
+type WorkspaceConfig struct {
+	Name string
+	Path string
+}
+
+// AmbiguousConfigError is returned if a workspace has several configs, but no default config.
+type AmbiguousConfigError struct {
+	Configs []WorkspaceConfig
+}
+
+func (e *AmbiguousConfigError) Error() string {
+	return fmt.Sprintf("found several devcontainer configs, select one with --config: %s", strings.Join(configNames(e.Configs), ", "))
+}
+
+// FindConfigs returns all configs of the workspace, the default config comes first. Like in the
+// spec .devcontainer/devcontainer.json takes precedence over .devcontainer.json.
+func FindConfigs(workspace string) ([]WorkspaceConfig, error) {
+	configs := []WorkspaceConfig{}
+	for _, file := range []string{
+		filepath.Join(workspace, ".devcontainer", "devcontainer.json"),
+		filepath.Join(workspace, ".devcontainer.json"),
+	} {
+		if _, err := os.Stat(file); err == nil {
+			configs = append(configs, WorkspaceConfig{Path: file})
+			break
+		}
+	}
+	named, err := filepath.Glob(filepath.Join(workspace, ".devcontainer", "*", "devcontainer.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(named)
+	for _, file := range named {
+		configs = append(configs, WorkspaceConfig{Name: filepath.Base(filepath.Dir(file)), Path: file})
+	}
+	return configs, nil
+}
+
+// SelectConfig returns the config of the workspace selected by name or by the path of a
+// devcontainer.json or its folder. Without selector it returns the default config, or the only
+// config of the workspace.
+func SelectConfig(workspace string, selector string) (WorkspaceConfig, error) {
+	configs, err := FindConfigs(workspace)
+	if err != nil {
+		return WorkspaceConfig{}, err
+	}
+	if selector == "" {
+		switch {
+		case len(configs) == 0:
+			return WorkspaceConfig{}, fmt.Errorf("no devcontainer config found in %s: %w", workspace, os.ErrNotExist)
+		case configs[0].Name == "" || len(configs) == 1:
+			return configs[0], nil
+		default:
+			return WorkspaceConfig{}, &AmbiguousConfigError{Configs: configs}
+		}
+	}
+	for _, config := range configs {
+		if config.Name == selector {
+			return config, nil
+		}
+	}
+	file, err := filepath.Abs(selector)
+	if err != nil {
+		return WorkspaceConfig{}, err
+	}
+	if info, err := os.Stat(file); err == nil && info.IsDir() {
+		file = filepath.Join(file, "devcontainer.json")
+	}
+	if _, err := os.Stat(file); err != nil {
+		return WorkspaceConfig{}, fmt.Errorf("unknown devcontainer config %q, found: %s", selector, strings.Join(configNames(configs), ", "))
+	}
+	for _, config := range configs {
+		if config.Path == file {
+			return config, nil
+		}
+	}
+	// configs outside of the known locations are named after their folder like named configs
+	return WorkspaceConfig{Name: filepath.Base(filepath.Dir(file)), Path: file}, nil
+}
+
+// localConfigFile returns the uncommitted local config next to a config file.
+func localConfigFile(file string) string {
+	return strings.TrimSuffix(file, ".json") + ".local.json"
+}
+
+func configNames(configs []WorkspaceConfig) []string {
+	names := []string{}
+	for _, config := range configs {
+		if config.Name == "" {
+			names = append(names, "(default)")
+		} else {
+			names = append(names, config.Name)
+		}
+	}
+	return names
+}
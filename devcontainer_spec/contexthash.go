@@ -0,0 +1,293 @@
+package devcontainerspec
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// hashBuildContext writes the paths, permissions and content digests of the files in the build
+// context to h. Only files that are copied by a COPY or ADD instruction of the Dockerfile are
+// hashed, unless the sources can not be determined. Files excluded by .dockerignore are skipped.
+func hashBuildContext(h io.Writer, contextDir string, dockerfile string) error {
+	sources, determined := dockerfileSources(dockerfile)
+	if determined && len(sources) == 0 {
+		return nil
+	}
+	ignore, err := ReadDockerignore(contextDir)
+	if err != nil {
+		return err
+	}
+	cache := loadDigestCache()
+	defer cache.save()
+	hashed := 0
+	err = filepath.Walk(contextDir, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(contextDir, file)
+		if err != nil {
+			return err
+		}
+		if relPath == "." {
+			return nil
+		}
+		relPath = filepath.ToSlash(relPath)
+		if ignore.Ignored(relPath) {
+			if info.IsDir() && !ignore.HasExceptions() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() || (determined && !referencedBy(relPath, sources)) {
+			return nil
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			link, err := os.Readlink(file)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(h, "%s -> %s\n", relPath, link)
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		digest, err := cache.digest(file, info)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(h, "%s %o %s\n", relPath, info.Mode().Perm(), digest)
+		hashed++
+		return nil
+	})
+	logger.Debug().Str("context", contextDir).Int("files", hashed).Bool("allFiles", !determined).Msg("hashed build context")
+	return err
+}
+
+// dockerfileSources returns the sources of all COPY and ADD instructions that refer to the
+// build context. The second result is false if the sources can not be determined, for
+// example because they contain variables, and the whole context has to be hashed.
+func dockerfileSources(dockerfile string) ([]string, bool) {
+	sources := []string{}
+	for _, instruction := range dockerfileInstructions(dockerfile) {
+		keyword, rest, _ := strings.Cut(instruction, " ")
+		keyword = strings.ToUpper(keyword)
+		if keyword != "COPY" && keyword != "ADD" {
+			continue
+		}
+		// flags come first, copies from other stages or images do not use the context
+		rest = strings.TrimSpace(rest)
+		fromStage := false
+		for strings.HasPrefix(rest, "--") {
+			flag, remaining, _ := strings.Cut(rest, " ")
+			fromStage = fromStage || strings.HasPrefix(flag, "--from=")
+			rest = strings.TrimSpace(remaining)
+		}
+		args, ok := instructionArgs(rest)
+		if !ok {
+			return nil, false
+		}
+		if fromStage || len(args) < 2 {
+			continue
+		}
+		for _, source := range args[:len(args)-1] {
+			switch {
+			case strings.HasPrefix(source, "<<"):
+				// the content of a heredoc is part of the Dockerfile
+			case keyword == "ADD" && (strings.Contains(source, "://") || strings.HasPrefix(source, "git@")):
+				// remote sources are not part of the context
+			case strings.Contains(source, "$"):
+				return nil, false
+			default:
+				sources = append(sources, source)
+			}
+		}
+	}
+	return sources, true
+}
+
+// buildKitFlags are instruction flags that only BuildKit supports.
+var buildKitFlags = []string{"--mount", "--network", "--security", "--link", "--chmod", "--checksum", "--parents", "--exclude", "--keep-git-dir"}
+
+var heredocPattern = regexp.MustCompile(`(^|\s)<<-?["']?[A-Za-z_]`)
+
+// BuildKitSyntax returns the first syntax of the Dockerfile that only BuildKit supports, e.g.
+// a "# syntax=" directive, "RUN --mount" or a heredoc, or an empty string if there is none.
+func BuildKitSyntax(dockerfile string) string {
+	// parser directives are comments at the very top of the Dockerfile
+	for _, line := range strings.Split(dockerfile, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "#") {
+			break
+		}
+		directive, _, found := strings.Cut(strings.TrimSpace(line[1:]), "=")
+		if found && strings.EqualFold(strings.TrimSpace(directive), "syntax") {
+			return "# syntax directive"
+		}
+	}
+	for _, instruction := range dockerfileInstructions(dockerfile) {
+		keyword, rest, _ := strings.Cut(instruction, " ")
+		keyword = strings.ToUpper(keyword)
+		for _, field := range strings.Fields(rest) {
+			if !strings.HasPrefix(field, "--") {
+				break
+			}
+			flag, _, _ := strings.Cut(field, "=")
+			if slices.Contains(buildKitFlags, flag) {
+				return keyword + " " + flag
+			}
+		}
+		if (keyword == "RUN" || keyword == "COPY" || keyword == "ADD") && heredocPattern.MatchString(rest) {
+			return "heredoc in " + keyword
+		}
+	}
+	return ""
+}
+
+// dockerfileInstructions returns the instructions of a Dockerfile with continuation lines
+// joined and comments removed.
+func dockerfileInstructions(dockerfile string) []string {
+	instructions := []string{}
+	current := ""
+	scanner := bufio.NewScanner(strings.NewReader(dockerfile))
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasSuffix(line, "\\") {
+			current += strings.TrimSuffix(line, "\\") + " "
+			continue
+		}
+		current += line
+		if strings.TrimSpace(current) != "" {
+			instructions = append(instructions, strings.TrimSpace(current))
+		}
+		current = ""
+	}
+	if strings.TrimSpace(current) != "" {
+		instructions = append(instructions, strings.TrimSpace(current))
+	}
+	return instructions
+}
+
+// instructionArgs splits the arguments of an instruction in shell or JSON form.
+func instructionArgs(rest string) ([]string, bool) {
+	if strings.HasPrefix(rest, "[") {
+		var args []string
+		if err := json.Unmarshal([]byte(rest), &args); err != nil {
+			return nil, false
+		}
+		return args, true
+	}
+	return strings.Fields(rest), true
+}
+
+// referencedBy reports whether a file is a source or below a source directory. Sources can be
+// glob patterns.
+func referencedBy(relPath string, sources []string) bool {
+	for _, source := range sources {
+		source = strings.TrimPrefix(path.Clean("/"+filepath.ToSlash(source)), "/")
+		if source == "" {
+			// the whole context is copied
+			return true
+		}
+		for p := relPath; p != "."; p = path.Dir(p) {
+			if matched, _ := path.Match(source, p); matched {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// fileDigest is a cached digest of a file, valid as long as size and modification time match.
+type fileDigest struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+	Digest  string    `json:"digest"`
+}
+
+// digestCache stores the digests of build context files between runs, so unchanged files of
+// large contexts are not read again.
+type digestCache struct {
+	path    string
+	entries map[string]fileDigest
+	changed bool
+}
+
+func loadDigestCache() *digestCache {
+	cache := &digestCache{entries: map[string]fileDigest{}}
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return cache
+	}
+	cache.path = filepath.Join(cacheDir, "devcli", "context-digests.json")
+	data, err := os.ReadFile(cache.path)
+	if err != nil {
+		return cache
+	}
+	if err := json.Unmarshal(data, &cache.entries); err != nil {
+		logger.Debug().Err(err).Str("path", cache.path).Msg("ignoring invalid digest cache")
+		cache.entries = map[string]fileDigest{}
+	}
+	return cache
+}
+
+func (c *digestCache) digest(file string, info os.FileInfo) (string, error) {
+	if entry, ok := c.entries[file]; ok && entry.Size == info.Size() && entry.ModTime.Equal(info.ModTime()) {
+		return entry.Digest, nil
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	digest := hex.EncodeToString(h.Sum(nil))
+	c.entries[file] = fileDigest{Size: info.Size(), ModTime: info.ModTime(), Digest: digest}
+	c.changed = true
+	return digest, nil
+}
+
+// save writes the cache if it changed. Entries of deleted files are dropped. Errors are only
+// logged, because the cache is an optimization.
+func (c *digestCache) save() {
+	if !c.changed || c.path == "" {
+		return
+	}
+	for file := range c.entries {
+		if _, err := os.Stat(file); os.IsNotExist(err) {
+			delete(c.entries, file)
+		}
+	}
+	data, err := json.Marshal(c.entries)
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(c.path), 0755)
+	}
+	if err == nil {
+		// write to a temporary file first, so concurrent runs never read a partial cache
+		tmp := c.path + fmt.Sprintf(".%d", os.Getpid())
+		if err = os.WriteFile(tmp, data, 0644); err == nil {
+			err = os.Rename(tmp, c.path)
+		}
+	}
+	if err != nil {
+		logger.Debug().Err(err).Str("path", c.path).Msg("could not save digest cache")
+	}
+}
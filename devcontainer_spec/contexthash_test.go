@@ -0,0 +1,28 @@
+package devcontainerspec
+
+import "testing"
+
+func TestBuildKitSyntax(t *testing.T) {
+	tests := []struct {
+		name       string
+		dockerfile string
+		want       string
+	}{
+		{name: "legacy", dockerfile: "FROM ubuntu\nRUN apt-get update && \\\n  apt-get install -y git\nCOPY --chown=dev . /src\n"},
+		{name: "syntax directive", dockerfile: "# syntax=docker/dockerfile:1\nFROM ubuntu\n", want: "# syntax directive"},
+		{name: "syntax directive after other directive", dockerfile: "# escape=`\n#syntax = docker/dockerfile:1.7\nFROM ubuntu\n", want: "# syntax directive"},
+		{name: "comment after instruction", dockerfile: "FROM ubuntu\n# syntax=docker/dockerfile:1\n"},
+		{name: "cache mount", dockerfile: "FROM golang\nRUN --mount=type=cache,target=/root/.cache go build ./...\n", want: "RUN --mount"},
+		{name: "copy link", dockerfile: "FROM ubuntu\nCOPY --link --chown=dev . /src\n", want: "COPY --link"},
+		{name: "run heredoc", dockerfile: "FROM ubuntu\nRUN <<EOF\napt-get update\nEOF\n", want: "heredoc in RUN"},
+		{name: "copy heredoc", dockerfile: "FROM ubuntu\nCOPY <<-\"EOT\" /etc/motd\nhello\nEOT\n", want: "heredoc in COPY"},
+		{name: "shift is no heredoc", dockerfile: "FROM ubuntu\nRUN echo $((1<<2))\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := BuildKitSyntax(test.dockerfile); got != test.want {
+				t.Errorf("expected %q, got %q", test.want, got)
+			}
+		})
+	}
+}
@@ -7,9 +7,9 @@ import (
 	"fmt"
 	"io"
 	"os"
-	"path"
 	"path/filepath"
-	"regexp"
+	"slices"
+	"sort"
 	"strings"
 )
 
@@ -17,6 +17,13 @@ const (
 	NamePrefix string = "devcli"
 )
 
+// values of "shutdownAction"
+const (
+	ShutdownNone          = "none"
+	ShutdownStopContainer = "stopContainer"
+	ShutdownStopCompose   = "stopCompose"
+)
+
 type RegistryAlias struct {
 	Original string `json:"original"`
 	Alias    string `json:"alias"`
@@ -24,67 +31,205 @@ type RegistryAlias struct {
 
 // DevcontainerConfig represents the key fields from a devcontainer.json file
 type DevcontainerConfig struct {
-	Name               string
-	DockerFileContent  string
-	Context            string
-	Image              string
-	Mounts             []string
-	RunArgs            []string
-	PostStartCommands  []string
-	PostCreateCommands []string
-	RegistryAliases    []RegistryAlias
+	Name              string
+	DockerFileContent string
+	Context           string
+	// BuildArgs, BuildTarget, CacheFrom and BuildOptions are passed to the image build
+	BuildArgs             map[string]string
+	BuildTarget           string
+	CacheFrom             []string
+	BuildOptions          []string
+	Image                 string
+	Mounts                []string
+	RunArgs               []string
+	InitializeCommands    []LifecycleCommand
+	OnCreateCommands      []LifecycleCommand
+	UpdateContentCommands []LifecycleCommand
+	PostCreateCommands    []LifecycleCommand
+	PostStartCommands     []LifecycleCommand
+	PostAttachCommands    []LifecycleCommand
+	// WaitFor is the lifecycle stage that has to finish before the shell is started
+	WaitFor         string
+	RegistryAliases []RegistryAlias
+	Features        []Feature
+	// FeaturesBaseImage is the image the features are installed on, if its user has to be
+	// taken from the image config
+	FeaturesBaseImage string `json:"-"`
+	// OverrideFeatureInstallOrder lists feature ids that are installed first, in the given order
+	OverrideFeatureInstallOrder []string
+	// ComposeFiles are the absolute paths of the docker compose files
+	ComposeFiles    []string
+	Service         string
+	RunServices     []string
+	WorkspaceFolder string
+	// WorkspaceMount replaces the default bind mount of the workspace, in the syntax of "--mount"
+	WorkspaceMount string
+	// ContainerUser runs the container, RemoteUser runs the commands in the container
+	ContainerUser string
+	RemoteUser    string
+	// UpdateRemoteUserUID changes the uid and gid of the remote user to the ones of the host
+	// user, it defaults to true
+	UpdateRemoteUserUID *bool
+	// ContainerEnv is set when the container is created
+	ContainerEnv map[string]string
+	// RemoteEnv is set for every command executed in the container, it does not influence the
+	// container itself and is therefore not part of the hash
+	RemoteEnv map[string]string `json:"-"`
+	// ShutdownAction is applied when the last session of the container ends, it does not
+	// influence the resulting container
+	ShutdownAction string `json:"-"`
+	// Runtime selects the container runtime, it does not influence the resulting container
+	Runtime string `json:"-"`
+}
+
+// StringSlice decodes a JSON string or an array of strings.
+type StringSlice []string
+
+func (s *StringSlice) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*s = StringSlice{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("expected a string or an array of strings: %w", err)
+	}
+	*s = list
+	return nil
 }
 
 type DevcontainerJson struct {
 	Name       string `json:"name,omitempty"`
 	DockerFile string `json:"dockerFile,omitempty"`
 	Build      struct {
-		Dockerfile string `json:"dockerfile,omitempty"`
-		Context    string `json:"context,omitempty"`
+		Dockerfile string            `json:"dockerfile,omitempty"`
+		Context    string            `json:"context,omitempty"`
+		Args       map[string]string `json:"args,omitempty"`
+		Target     string            `json:"target,omitempty"`
+		CacheFrom  StringSlice       `json:"cacheFrom,omitempty"`
+		Options    []string          `json:"options,omitempty"`
 	} `json:"build"`
-	Image             string   `json:"image,omitempty"`
-	Mounts            []string `json:"mounts,omitempty"`
-	RunArgs           []string `json:"runArgs,omitempty"`
-	PostStartCommand  string   `json:"postStartCommand,omitempty"`
-	PostCreateCommand string   `json:"postCreateCommand,omitempty"`
-	Customizations    struct {
+	Image                string           `json:"image,omitempty"`
+	Mounts               []string         `json:"mounts,omitempty"`
+	RunArgs              []string         `json:"runArgs,omitempty"`
+	InitializeCommand    LifecycleCommand `json:"initializeCommand,omitempty"`
+	OnCreateCommand      LifecycleCommand `json:"onCreateCommand,omitempty"`
+	UpdateContentCommand LifecycleCommand `json:"updateContentCommand,omitempty"`
+	PostCreateCommand    LifecycleCommand `json:"postCreateCommand,omitempty"`
+	PostStartCommand     LifecycleCommand `json:"postStartCommand,omitempty"`
+	PostAttachCommand    LifecycleCommand `json:"postAttachCommand,omitempty"`
+	WaitFor              string           `json:"waitFor,omitempty"`
+	// Features maps the feature reference to its options
+	Features                    map[string]json.RawMessage `json:"features,omitempty"`
+	OverrideFeatureInstallOrder []string                   `json:"overrideFeatureInstallOrder,omitempty"`
+	DockerComposeFile           StringSlice                `json:"dockerComposeFile,omitempty"`
+	Service                     string                     `json:"service,omitempty"`
+	RunServices                 []string                   `json:"runServices,omitempty"`
+	WorkspaceFolder             string                     `json:"workspaceFolder,omitempty"`
+	WorkspaceMount              string                     `json:"workspaceMount,omitempty"`
+	ContainerEnv                map[string]string          `json:"containerEnv,omitempty"`
+	ContainerUser               string                     `json:"containerUser,omitempty"`
+	ShutdownAction              string                     `json:"shutdownAction,omitempty"`
+	RemoteUser                  string                     `json:"remoteUser,omitempty"`
+	UpdateRemoteUserUID         *bool                      `json:"updateRemoteUserUID,omitempty"`
+	RemoteEnv                   map[string]string          `json:"remoteEnv,omitempty"`
+	Customizations              struct {
 		Devcli struct {
 			RegistryAliases []RegistryAlias `json:"registryAliases"`
+			Runtime         string          `json:"runtime,omitempty"`
 		} `json:"devcli"`
 	} `json:"customizations"`
+	// configDir is the directory of the devcontainer.json, local features are relative to it
+	configDir string
 }
 
 type Devcontainer struct {
 	Cwd    string
 	Config DevcontainerConfig
 	Hash   string
+	// ConfigName is the name of the selected config of the workspace, empty for the default config
+	ConfigName string
+	// ConfigPath is the devcontainer.json of the selected config
+	ConfigPath string
+	// Sources maps the field names of Config to the files that set them, in merge order
+	Sources map[string][]string
+}
+
+// systemConfigFile is the devcontainer.json of the machine, it is merged before the user config.
+var systemConfigFile = filepath.Join("/etc", "devcli", "devcontainer.json")
+
+// configLayer is a devcontainer.json that is merged into the config, later layers take precedence.
+type configLayer struct {
+	name string
+	file string
+	// optional layers are skipped if the file does not exist
+	optional bool
 }
 
-func ParseDevcontainer(path string) (Devcontainer, error) {
-	devc := Devcontainer{}
+// configLayers returns the config files in merge order: the system config, the user config,
+// the project config and the uncommitted local config next to the project config.
+func configLayers(project WorkspaceConfig) ([]configLayer, error) {
 	userConfigDir, err := os.UserConfigDir()
+	if err != nil {
+		return nil, err
+	}
+	return []configLayer{
+		{name: "system", file: systemConfigFile, optional: true},
+		{name: "user", file: filepath.Join(userConfigDir, "devcli", ".devcontainer", "devcontainer.json"), optional: true},
+		{name: "project", file: project.Path},
+		{name: "local", file: localConfigFile(project.Path), optional: true},
+	}, nil
+}
+
+// ParseDevcontainer merges the config layers of the selected config of the workspace path.
+func ParseDevcontainer(path string, config WorkspaceConfig) (Devcontainer, error) {
+	devc := Devcontainer{Cwd: path, ConfigName: config.Name, ConfigPath: config.Path}
+	layers, err := configLayers(config)
 	if err != nil {
 		return Devcontainer{}, err
 	}
-	homeConfigDir := filepath.Join(userConfigDir, "devcli")
-	homeConfigDevcontainer := filepath.Join(homeConfigDir, ".devcontainer", "devcontainer.json")
-	if _, err := os.Stat(homeConfigDevcontainer); err == nil {
-		logger.Debug().Msgf("Parsing devcontainer json from path: %s", homeConfigDir)
-		globalData, err := parseDevcontainerJson(homeConfigDir)
+	for _, layer := range layers {
+		if _, err := os.Stat(layer.file); layer.optional && os.IsNotExist(err) {
+			continue
+		}
+		logger.Debug().Str("layer", layer.name).Msgf("Parsing devcontainer json from path: %s", layer.file)
+		data, err := parseDevcontainerJson(layer.file, path)
 		if err != nil {
 			return Devcontainer{}, err
 		}
-		logger.Debug().Msgf("Parsed devcontainer config: %+v", globalData)
-		devc.Merge(globalData)
+		logger.Debug().Msgf("Parsed devcontainer config: %+v", data)
+		if err := devc.mergeFrom(data, layer); err != nil {
+			return Devcontainer{}, err
+		}
 	}
-	devc.Cwd = path
-	logger.Debug().Msgf("Parsing devcontainer json from path: %s", path)
-	projectData, err := parseDevcontainerJson(path)
-	if err != nil {
+	devc.substituteContainerWorkspaceFolder()
+	if devc.IsCompose() {
+		if devc.Config.Service == "" {
+			return Devcontainer{}, fmt.Errorf("dockerComposeFile is set, but no service")
+		}
+		if len(devc.Config.Features) > 0 {
+			return Devcontainer{}, fmt.Errorf("features are not supported for docker compose devcontainers")
+		}
+	}
+	switch devc.Config.ShutdownAction {
+	case "":
+		// devcli keeps containers running by default, so they start fast next time
+		devc.Config.ShutdownAction = ShutdownNone
+	case ShutdownNone, ShutdownStopContainer:
+	case ShutdownStopCompose:
+		if !devc.IsCompose() {
+			return Devcontainer{}, fmt.Errorf("shutdownAction %q needs dockerComposeFile", ShutdownStopCompose)
+		}
+	default:
+		return Devcontainer{}, fmt.Errorf("unknown shutdownAction %q", devc.Config.ShutdownAction)
+	}
+	if devc.Config.WorkspaceMount != "" && devc.Config.WorkspaceFolder == "" {
+		return Devcontainer{}, fmt.Errorf("workspaceMount is set, but no workspaceFolder")
+	}
+	if err := devc.applyFeatures(); err != nil {
 		return Devcontainer{}, err
 	}
-	logger.Debug().Msgf("Parsed devcontainer config: %+v", projectData)
-	devc.Merge(projectData)
 	hash, err := calculateDevcontainerHash(devc)
 	if err != nil {
 		return Devcontainer{}, err
@@ -95,61 +240,41 @@ func ParseDevcontainer(path string) (Devcontainer, error) {
 	return devc, nil
 }
 
-// parseDevcontainerConfig reads a devcontainer.json file from the specified path,
-// cleans JSON5 features like comments, applies regex replacements,
-// and extracts the key configuration elements
-func parseDevcontainerJson(path string) (DevcontainerJson, error) {
-	devPath := filepath.Join(path, ".devcontainer", "devcontainer.json")
+// parseDevcontainerConfig reads the devcontainer.json file devPath,
+// strips comments and trailing commas, substitutes variables in the parsed values
+// and extracts the key configuration elements. Workspace variables refer to workspace.
+func parseDevcontainerJson(devPath string, workspace string) (DevcontainerJson, error) {
 	data, err := os.ReadFile(devPath)
 	if err != nil {
 		return DevcontainerJson{}, err
 	}
 
-	// Convert to string for preprocessing
-	jsonStr := string(data)
-
-	// Remove single line comments (//...); either directly at the start of the line, or a whitespace character followed by "//"
-	re := regexp.MustCompile(`(?m)((^)|([ \t]+))//.*`)
-	jsonStr = re.ReplaceAllString(jsonStr, "")
-
-	// Remove multi-line comments (/* ... */)
-	re = regexp.MustCompile(`/\*[\s\S]*?\*/`)
-	jsonStr = re.ReplaceAllString(jsonStr, "")
-
-	// Remove trailing commas in objects and arrays
-	re = regexp.MustCompile(`,\s*}`)
-	jsonStr = re.ReplaceAllString(jsonStr, "}")
-	re = regexp.MustCompile(`,\s*]`)
-	jsonStr = re.ReplaceAllString(jsonStr, "]")
-
-	// Replace environment variable references with their values
-	re = regexp.MustCompile(`\${localEnv:(.+?)}`)
-	matches := re.FindAllStringSubmatch(jsonStr, -1)
-	for _, match := range matches {
-		envVar := match[1]
-		envValue, exists := os.LookupEnv(envVar)
-		if !exists {
-			logger.Warn().Str("env", envVar).Msg("environment variable is not set")
-		}
-		logger.Debug().Str("env", envVar).Str("value", envValue).Msg("set env variable")
-		jsonStr = strings.ReplaceAll(jsonStr, match[0], envValue)
+	// Remove comments and trailing commas outside of strings
+	stripped, err := stripJSONC(devPath, data)
+	if err != nil {
+		return DevcontainerJson{}, err
+	}
+	// decode once before the substitution, so errors point to the right position in the file
+	var tree any
+	if err := jsonError(devPath, data, json.Unmarshal(stripped, &tree)); err != nil {
+		return DevcontainerJson{}, err
 	}
-
-	// replace workplace reference
-	re = regexp.MustCompile(`\${localWorkspaceFolder}`)
-	jsonStr = re.ReplaceAllString(jsonStr, path)
-	re = regexp.MustCompile(`\${localWorkspaceFolderBasename}`)
-	localWorkspaceFolderBasename := filepath.Base(path)
-	jsonStr = re.ReplaceAllString(jsonStr, localWorkspaceFolderBasename)
-	re = regexp.MustCompile(`\${containerWorkspaceFolder}`)
-	containerWorkspaceFolder := filepath.Join("/workspaces", localWorkspaceFolderBasename)
-	jsonStr = re.ReplaceAllString(jsonStr, containerWorkspaceFolder)
-
-	// Parse the cleaned JSON
 	var jsonData DevcontainerJson
-	if err := json.Unmarshal([]byte(jsonStr), &jsonData); err != nil {
-		return DevcontainerJson{}, fmt.Errorf("failed to unmarshal devcontainer config: %w, %s", err, jsonStr)
+	if err := jsonError(devPath, data, json.Unmarshal(stripped, &jsonData)); err != nil {
+		return DevcontainerJson{}, err
+	}
+
+	// Replace variables in the parsed values, so substituted values need no escaping
+	ctx := newSubstitutionContext(devPath, workspace)
+	substituted, err := json.Marshal(ctx.substitute(tree, "$"))
+	if err != nil {
+		return DevcontainerJson{}, err
 	}
+	jsonData = DevcontainerJson{}
+	if err := json.Unmarshal(substituted, &jsonData); err != nil {
+		return DevcontainerJson{}, fmt.Errorf("%s: failed to unmarshal devcontainer config: %w", devPath, err)
+	}
+	jsonData.configDir = filepath.Dir(devPath)
 
 	return jsonData, nil
 }
@@ -158,13 +283,19 @@ func parseDevcontainerJson(path string) (DevcontainerJson, error) {
 // - The current working directory
 // - The entire DevcontainerConfig content
 // - The content of the Dockerfile if specified in the config
+// - The files of the build context that are copied by the Dockerfile
+// - The content of all docker compose files
 // This hash can be used to identify unique development environment configurations
 func calculateDevcontainerHash(devc Devcontainer) (string, error) {
 	// Create a hash builder
 	h := sha256.New()
 
-	// Add current working directory to hash
+	// Add current working directory and the name of the config to hash, so the configs of a
+	// workspace can run side by side
 	io.WriteString(h, devc.Cwd)
+	if devc.ConfigName != "" {
+		io.WriteString(h, "\x00"+devc.ConfigName)
+	}
 
 	// Add config content to hash
 	configJSON, err := json.Marshal(devc.Config)
@@ -173,9 +304,21 @@ func calculateDevcontainerHash(devc Devcontainer) (string, error) {
 	}
 	h.Write(configJSON)
 
-	// If Dockerfile is specified, add its content to hash
+	// If Dockerfile is specified, add its content and the files it copies from the build context to hash
 	if devc.Config.DockerFileContent != "" {
 		h.Write([]byte(devc.Config.DockerFileContent))
+		if err := hashBuildContext(h, devc.GetBuildContext(), devc.Config.DockerFileContent); err != nil {
+			return "", fmt.Errorf("failed to hash build context: %w", err)
+		}
+	}
+
+	// Add the content of all docker compose files to hash
+	for _, composeFile := range devc.Config.ComposeFiles {
+		content, err := os.ReadFile(composeFile)
+		if err != nil {
+			return "", fmt.Errorf("failed to read compose file: %w", err)
+		}
+		h.Write(content)
 	}
 
 	// Generate the final hash
@@ -197,38 +340,272 @@ func (devc Devcontainer) GetContainerName() string {
 	return devc.GetDevcNamePrefix() + devc.Hash[0:7]
 }
 
+// GetRemoteUser returns the user for commands in the container. Like in the spec it defaults
+// to the container user. An empty user leaves the choice to the runtime.
+func (devc Devcontainer) GetRemoteUser() string {
+	if devc.Config.RemoteUser != "" {
+		return devc.Config.RemoteUser
+	}
+	return devc.Config.ContainerUser
+}
+
+// GetRemoteUserImageName returns the name of the image derived from the devcontainer image,
+// in which the uid and gid of the remote user match the host user.
+func (devc Devcontainer) GetRemoteUserImageName() string {
+	return devc.GetDevcNamePrefix() + devc.Hash[0:7] + "-uid"
+}
+
+// GetBuildContext returns the directory of the build context, which is relative to the
+// folder of the devcontainer.json.
+func (devc Devcontainer) GetBuildContext() string {
+	return filepath.Join(filepath.Dir(devc.ConfigPath), devc.Config.Context)
+}
+
+// IsCompose reports whether the devcontainer is a service of a docker compose project.
+func (devc Devcontainer) IsCompose() bool {
+	return len(devc.Config.ComposeFiles) > 0
+}
+
+// GetComposeProject returns the compose project name. Unlike the container name it does not
+// contain the hash, so a changed config updates the running project instead of starting a
+// second one next to it.
+func (devc Devcontainer) GetComposeProject() string {
+	return strings.TrimSuffix(devc.GetDevcNamePrefix(), "_")
+}
+
+// GetWorkspaceFolder returns the folder the shell is started in. Compose setups default to "/"
+// like in the spec, because the workspace is mounted by the compose file.
+func (devc Devcontainer) GetWorkspaceFolder() string {
+	if devc.Config.WorkspaceFolder != "" {
+		return devc.Config.WorkspaceFolder
+	}
+	if devc.IsCompose() {
+		return "/"
+	}
+	return "/workspaces/" + filepath.Base(devc.Cwd)
+}
+
 func (devc Devcontainer) GetDevcNamePrefix() string {
-	return NamePrefix + "_" + strings.ToLower(filepath.Base(devc.Cwd)) + "_"
+	prefix := NamePrefix + "_" + strings.ToLower(filepath.Base(devc.Cwd)) + "_"
+	if devc.ConfigName != "" {
+		prefix += strings.ToLower(devc.ConfigName) + "_"
+	}
+	return prefix
 }
 
-// Merge a DevcontainerJson into the devcontainer config.
-// Single arguments get overwritten, arrays get appended.
+// Merge a DevcontainerJson into the devcontainer config, so later configs extend the earlier ones.
+// Single values are only overwritten if devj sets them, maps are merged by key, lists are
+// appended. Mounts replace earlier mounts with the same target and repeated run arguments and
+// build options are dropped. Empty lifecycle commands are skipped.
 func (devc *Devcontainer) Merge(devj DevcontainerJson) error {
-	devc.Config.Name = devj.Name
+	if devj.Name != "" {
+		devc.Config.Name = devj.Name
+	}
 	// the dockerfile can be defined either with "dockerFile" directly or with "build.dockerfile"
 	// we check both and give the "build.dockerfile" priority
-	if devj.DockerFile != "" {
-		content, err := os.ReadFile(path.Join(devc.Cwd, ".devcontainer", devj.DockerFile))
+	dockerfile := devj.DockerFile
+	if devj.Build.Dockerfile != "" {
+		dockerfile = devj.Build.Dockerfile
+	}
+	if dockerfile != "" {
+		content, err := os.ReadFile(filepath.Join(devj.configDir, dockerfile))
 		if err != nil {
 			return err
 		}
 		devc.Config.DockerFileContent = string(content)
+		// an image and a dockerfile exclude each other, the later config decides
+		devc.Config.Image = ""
 	}
-	if devj.Build.Dockerfile != "" {
-		content, err := os.ReadFile(path.Join(devc.Cwd, ".devcontainer", devj.Build.Dockerfile))
+	if devj.Image != "" {
+		devc.Config.Image = devj.Image
+		devc.Config.DockerFileContent = ""
+	}
+	if devj.Build.Context != "" {
+		devc.Config.Context = devj.Build.Context
+	}
+	devc.Config.BuildArgs = mergeEnv(devc.Config.BuildArgs, devj.Build.Args)
+	if devj.Build.Target != "" {
+		devc.Config.BuildTarget = devj.Build.Target
+	}
+	devc.Config.CacheFrom = appendUnique(devc.Config.CacheFrom, devj.Build.CacheFrom...)
+	devc.Config.BuildOptions = appendOptions(devc.Config.BuildOptions, devj.Build.Options)
+	devc.Config.Mounts = appendMounts(devc.Config.Mounts, devj.Mounts)
+	devc.Config.RunArgs = appendOptions(devc.Config.RunArgs, devj.RunArgs)
+	devc.Config.InitializeCommands = appendCommand(devc.Config.InitializeCommands, devj.InitializeCommand)
+	devc.Config.OnCreateCommands = appendCommand(devc.Config.OnCreateCommands, devj.OnCreateCommand)
+	devc.Config.UpdateContentCommands = appendCommand(devc.Config.UpdateContentCommands, devj.UpdateContentCommand)
+	devc.Config.PostCreateCommands = appendCommand(devc.Config.PostCreateCommands, devj.PostCreateCommand)
+	devc.Config.PostStartCommands = appendCommand(devc.Config.PostStartCommands, devj.PostStartCommand)
+	devc.Config.PostAttachCommands = appendCommand(devc.Config.PostAttachCommands, devj.PostAttachCommand)
+	if devj.WaitFor != "" {
+		devc.Config.WaitFor = devj.WaitFor
+	}
+	devc.Config.RegistryAliases = append(devc.Config.RegistryAliases, devj.Customizations.Devcli.RegistryAliases...)
+	if len(devj.DockerComposeFile) > 0 {
+		devc.Config.ComposeFiles = []string{}
+		for _, composeFile := range devj.DockerComposeFile {
+			devc.Config.ComposeFiles = append(devc.Config.ComposeFiles, filepath.Join(devj.configDir, composeFile))
+		}
+	}
+	if devj.Service != "" {
+		devc.Config.Service = devj.Service
+	}
+	if len(devj.RunServices) > 0 {
+		devc.Config.RunServices = devj.RunServices
+	}
+	if devj.WorkspaceFolder != "" {
+		devc.Config.WorkspaceFolder = devj.WorkspaceFolder
+	}
+	if devj.WorkspaceMount != "" {
+		devc.Config.WorkspaceMount = devj.WorkspaceMount
+	}
+	if devj.ContainerUser != "" {
+		devc.Config.ContainerUser = devj.ContainerUser
+	}
+	if devj.RemoteUser != "" {
+		devc.Config.RemoteUser = devj.RemoteUser
+	}
+	if devj.UpdateRemoteUserUID != nil {
+		devc.Config.UpdateRemoteUserUID = devj.UpdateRemoteUserUID
+	}
+	devc.Config.ContainerEnv = mergeEnv(devc.Config.ContainerEnv, devj.ContainerEnv)
+	devc.Config.RemoteEnv = mergeEnv(devc.Config.RemoteEnv, devj.RemoteEnv)
+	if err := devc.mergeFeatures(devj); err != nil {
+		return err
+	}
+	if len(devj.OverrideFeatureInstallOrder) > 0 {
+		devc.Config.OverrideFeatureInstallOrder = devj.OverrideFeatureInstallOrder
+	}
+	if devj.ShutdownAction != "" {
+		devc.Config.ShutdownAction = devj.ShutdownAction
+	}
+	if devj.Customizations.Devcli.Runtime != "" {
+		devc.Config.Runtime = devj.Customizations.Devcli.Runtime
+	}
+	devc.ApplyRegistryAliases()
+	return nil
+}
+
+// appendCommand appends a lifecycle command unless it is empty.
+func appendCommand(commands []LifecycleCommand, command LifecycleCommand) []LifecycleCommand {
+	if command.IsEmpty() {
+		return commands
+	}
+	return append(commands, command)
+}
+
+// appendUnique appends the values that are not in list yet.
+func appendUnique(list []string, values ...string) []string {
+	for _, value := range values {
+		if !slices.Contains(list, value) {
+			list = append(list, value)
+		}
+	}
+	return list
+}
+
+// appendMounts appends mounts in the "--mount" syntax. A mount replaces an earlier mount with
+// the same target, because the runtime rejects duplicate mount points.
+func appendMounts(mounts []string, values []string) []string {
+	for _, value := range values {
+		target := mountTarget(value)
+		mounts = slices.DeleteFunc(mounts, func(mount string) bool {
+			return mount == value || (target != "" && mountTarget(mount) == target)
+		})
+		mounts = append(mounts, value)
+	}
+	return mounts
+}
+
+// mountTarget returns the target of a mount in the "--mount" syntax.
+func mountTarget(mount string) string {
+	for _, field := range strings.Split(mount, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(field), "=")
+		switch strings.ToLower(key) {
+		case "target", "destination", "dst":
+			return value
+		}
+	}
+	return ""
+}
+
+// appendOptions appends command line options like run arguments. An option is a flag together
+// with its separate values, e.g. ["--cap-add", "SYS_PTRACE"]. Options that are already set
+// are skipped, so a flag given by several configs is only passed once.
+func appendOptions(list []string, values []string) []string {
+	existing := splitOptions(list)
+	for _, option := range splitOptions(values) {
+		if !slices.ContainsFunc(existing, func(other []string) bool { return slices.Equal(other, option) }) {
+			existing = append(existing, option)
+			list = append(list, option...)
+		}
+	}
+	return list
+}
+
+// splitOptions groups arguments into options, every argument starting with "-" begins a new one.
+func splitOptions(args []string) [][]string {
+	options := [][]string{}
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "-") || len(options) == 0 {
+			options = append(options, []string{arg})
+		} else {
+			options[len(options)-1] = append(options[len(options)-1], arg)
+		}
+	}
+	return options
+}
+
+// mergeEnv adds the variables of env to base, existing variables are overwritten.
+// It is also used for other string maps like the build arguments.
+func mergeEnv(base map[string]string, env map[string]string) map[string]string {
+	if len(env) == 0 {
+		return base
+	}
+	if base == nil {
+		base = map[string]string{}
+	}
+	for key, value := range env {
+		base[key] = value
+	}
+	return base
+}
+
+// EnvList converts environment variables into the sorted KEY=value form used by the runtimes.
+func EnvList(env map[string]string) []string {
+	list := make([]string, 0, len(env))
+	for key, value := range env {
+		list = append(list, key+"="+value)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// mergeFeatures resolves the features of devj. A feature that is already set is replaced,
+// a feature set to false is removed.
+func (devc *Devcontainer) mergeFeatures(devj DevcontainerJson) error {
+	sources := make([]string, 0, len(devj.Features))
+	for source := range devj.Features {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+	for _, source := range sources {
+		features := []Feature{}
+		for _, feature := range devc.Config.Features {
+			if feature.Source != source {
+				features = append(features, feature)
+			}
+		}
+		devc.Config.Features = features
+		if string(devj.Features[source]) == "false" {
+			continue
+		}
+		feature, err := resolveFeature(source, devj.Features[source], devj.configDir)
 		if err != nil {
 			return err
 		}
-		devc.Config.DockerFileContent = string(content)
+		devc.Config.Features = append(devc.Config.Features, feature)
 	}
-	devc.Config.Context = devj.Build.Context
-	devc.Config.Image = devj.Image
-	devc.Config.Mounts = append(devc.Config.Mounts, devj.Mounts...)
-	devc.Config.RunArgs = append(devc.Config.RunArgs, devj.RunArgs...)
-	devc.Config.PostStartCommands = append(devc.Config.PostStartCommands, devj.PostStartCommand)
-	devc.Config.PostCreateCommands = append(devc.Config.PostCreateCommands, devj.PostCreateCommand)
-	devc.Config.RegistryAliases = append(devc.Config.RegistryAliases, devj.Customizations.Devcli.RegistryAliases...)
-	devc.ApplyRegistryAliases()
 	return nil
 }
 
@@ -0,0 +1,132 @@
+package devcontainerspec
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMerge(t *testing.T) {
+	configDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(configDir, "Dockerfile"), []byte("FROM ubuntu\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		base    string
+		project string
+		field   func(config DevcontainerConfig) any
+		want    any
+	}{
+		{
+			name:    "scalar kept if the project omits it",
+			base:    `{"remoteUser": "dev", "workspaceFolder": "/src", "waitFor": "postCreateCommand"}`,
+			project: `{"name": "project"}`,
+			field: func(c DevcontainerConfig) any {
+				return []string{c.RemoteUser, c.WorkspaceFolder, c.WaitFor}
+			},
+			want: []string{"dev", "/src", "postCreateCommand"},
+		},
+		{
+			name:    "scalar overwritten by the project",
+			base:    `{"remoteUser": "dev", "build": {"target": "base"}}`,
+			project: `{"remoteUser": "root", "build": {"target": "dev"}}`,
+			field:   func(c DevcontainerConfig) any { return []string{c.RemoteUser, c.BuildTarget} },
+			want:    []string{"root", "dev"},
+		},
+		{
+			name:    "image replaces dockerfile",
+			base:    `{"build": {"dockerfile": "Dockerfile"}}`,
+			project: `{"image": "alpine"}`,
+			field:   func(c DevcontainerConfig) any { return []string{c.Image, c.DockerFileContent} },
+			want:    []string{"alpine", ""},
+		},
+		{
+			name:    "dockerfile replaces image",
+			base:    `{"image": "alpine"}`,
+			project: `{"dockerFile": "Dockerfile"}`,
+			field:   func(c DevcontainerConfig) any { return []string{c.Image, c.DockerFileContent} },
+			want:    []string{"", "FROM ubuntu\n"},
+		},
+		{
+			name:    "maps merged by key",
+			base:    `{"containerEnv": {"A": "1", "B": "1"}, "build": {"args": {"VERSION": "1"}}}`,
+			project: `{"containerEnv": {"B": "2", "C": "2"}, "build": {"args": {"USER": "dev"}}}`,
+			field: func(c DevcontainerConfig) any {
+				return []map[string]string{c.ContainerEnv, c.BuildArgs}
+			},
+			want: []map[string]string{{"A": "1", "B": "2", "C": "2"}, {"VERSION": "1", "USER": "dev"}},
+		},
+		{
+			name:    "remoteEnv merged by key",
+			base:    `{"remoteEnv": {"EDITOR": "vim"}}`,
+			project: `{"remoteEnv": {"EDITOR": "nano", "PAGER": "less"}}`,
+			field:   func(c DevcontainerConfig) any { return c.RemoteEnv },
+			want:    map[string]string{"EDITOR": "nano", "PAGER": "less"},
+		},
+		{
+			name:    "mounts deduped by target",
+			base:    `{"mounts": ["source=cache,target=/cache,type=volume", "source=/tmp,target=/tmp,type=bind"]}`,
+			project: `{"mounts": ["source=other,dst=/cache,type=volume", "source=/tmp,target=/tmp,type=bind"]}`,
+			field:   func(c DevcontainerConfig) any { return c.Mounts },
+			want:    []string{"source=other,dst=/cache,type=volume", "source=/tmp,target=/tmp,type=bind"},
+		},
+		{
+			name:    "runArgs deduped as flag and value",
+			base:    `{"runArgs": ["--cap-add", "SYS_PTRACE", "--init"]}`,
+			project: `{"runArgs": ["--init", "--cap-add", "SYS_PTRACE", "--cap-add", "NET_ADMIN"]}`,
+			field:   func(c DevcontainerConfig) any { return c.RunArgs },
+			want:    []string{"--cap-add", "SYS_PTRACE", "--init", "--cap-add", "NET_ADMIN"},
+		},
+		{
+			name:    "build options deduped as flag and value",
+			base:    `{"build": {"options": ["--network", "host"]}}`,
+			project: `{"build": {"options": ["--network", "host", "--add-host", "db:10.0.0.2"]}}`,
+			field:   func(c DevcontainerConfig) any { return c.BuildOptions },
+			want:    []string{"--network", "host", "--add-host", "db:10.0.0.2"},
+		},
+		{
+			name:    "cacheFrom appended once",
+			base:    `{"build": {"cacheFrom": "ghcr.io/org/cache"}}`,
+			project: `{"build": {"cacheFrom": ["ghcr.io/org/cache", "ghcr.io/org/other"]}}`,
+			field:   func(c DevcontainerConfig) any { return c.CacheFrom },
+			want:    []string{"ghcr.io/org/cache", "ghcr.io/org/other"},
+		},
+		{
+			name:    "lifecycle commands appended",
+			base:    `{"postCreateCommand": "make deps"}`,
+			project: `{"postCreateCommand": ["make", "build"]}`,
+			field:   func(c DevcontainerConfig) any { return c.PostCreateCommands },
+			want:    []LifecycleCommand{{Shell: "make deps"}, {Args: []string{"make", "build"}}},
+		},
+		{
+			name:    "empty lifecycle commands skipped",
+			base:    `{"postStartCommand": "", "onCreateCommand": []}`,
+			project: `{"postStartCommand": null, "onCreateCommand": {}}`,
+			field: func(c DevcontainerConfig) any {
+				return len(c.PostStartCommands) + len(c.OnCreateCommands)
+			},
+			want: 0,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var devc Devcontainer
+			for _, layer := range []string{test.base, test.project} {
+				var devj DevcontainerJson
+				if err := json.Unmarshal([]byte(layer), &devj); err != nil {
+					t.Fatal(err)
+				}
+				devj.configDir = configDir
+				if err := devc.Merge(devj); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := test.field(devc.Config); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("expected %#v, got %#v", test.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package devcontainerspec
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+type ignorePattern struct {
+	re *regexp.Regexp
+	// negate is set for "!" patterns, which re-include the matching paths
+	negate bool
+}
+
+// Dockerignore holds the patterns of a .dockerignore file. The last matching pattern wins,
+// patterns starting with "!" re-include previously excluded paths.
+type Dockerignore struct {
+	patterns []ignorePattern
+}
+
+// ReadDockerignore reads the .dockerignore file of a build context. A missing file ignores nothing.
+func ReadDockerignore(contextDir string) (Dockerignore, error) {
+	f, err := os.Open(filepath.Join(contextDir, ".dockerignore"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return Dockerignore{}, nil
+		}
+		return Dockerignore{}, err
+	}
+	defer f.Close()
+	var ignore Dockerignore
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		negate := strings.HasPrefix(line, "!")
+		line = strings.TrimPrefix(line, "!")
+		line = strings.TrimPrefix(filepath.ToSlash(filepath.Clean(line)), "/")
+		ignore.patterns = append(ignore.patterns, ignorePattern{re: compileIgnorePattern(line), negate: negate})
+	}
+	return ignore, scanner.Err()
+}
+
+// compileIgnorePattern converts a .dockerignore pattern into a regexp. A pattern matching a
+// directory also matches everything below it.
+func compileIgnorePattern(pattern string) *regexp.Regexp {
+	var sb strings.Builder
+	sb.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		ch := pattern[i]
+		switch {
+		case strings.HasPrefix(pattern[i:], "**/"):
+			// "**/" matches zero or more whole directories
+			sb.WriteString("(.*/)?")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "**"):
+			sb.WriteString(".*")
+			i++
+		case ch == '*':
+			sb.WriteString("[^/]*")
+		case ch == '?':
+			sb.WriteString("[^/]")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(ch)))
+		}
+	}
+	sb.WriteString("(/.*)?$")
+	return regexp.MustCompile(sb.String())
+}
+
+// Ignored reports whether the slash separated path relative to the context is excluded.
+func (ignore Dockerignore) Ignored(relPath string) bool {
+	ignored := false
+	for _, pattern := range ignore.patterns {
+		if pattern.re.MatchString(relPath) {
+			ignored = !pattern.negate
+		}
+	}
+	return ignored
+}
+
+// HasExceptions reports whether any pattern re-includes paths, in which case
+// ignored directories still have to be walked.
+func (ignore Dockerignore) HasExceptions() bool {
+	for _, pattern := range ignore.patterns {
+		if pattern.negate {
+			return true
+		}
+	}
+	return false
+}
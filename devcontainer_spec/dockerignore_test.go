@@ -0,0 +1,36 @@
+package devcontainerspec
+
+import "testing"
+
+func TestCompileIgnorePattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		match   bool
+	}{
+		{"node_modules", "node_modules", true},
+		{"node_modules", "node_modules/pkg/index.js", true},
+		{"node_modules", "src/node_modules", false},
+		{"*.log", "debug.log", true},
+		{"*.log", "logs/debug.log", false},
+		{"**/*.log", "debug.log", true},
+		{"**/*.log", "logs/debug.log", true},
+		{"**/foo", "foo", true},
+		{"**/foo", "a/b/foo", true},
+		{"**/foo", "barfoo", false},
+		{"**/foo", "a/barfoo", false},
+		{"a/**/b", "a/b", true},
+		{"a/**/b", "a/x/y/b", true},
+		{"a/**/b", "a/xb", false},
+		{"a/**", "a/x/y", true},
+		{"file?.txt", "file1.txt", true},
+		{"file?.txt", "file/.txt", false},
+	}
+	for _, test := range tests {
+		t.Run(test.pattern+" "+test.path, func(t *testing.T) {
+			if match := compileIgnorePattern(test.pattern).MatchString(test.path); match != test.match {
+				t.Errorf("expected %v, got %v", test.match, match)
+			}
+		})
+	}
+}
@@ -0,0 +1,322 @@
+package devcontainerspec
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	ociManifestMediaType = "application/vnd.oci.image.manifest.v1+json"
+	featureLayerType     = "application/vnd.devcontainers.layer.v1+tar"
+	// featureRefTTL is how long a resolved feature reference is used without fetching it again
+	featureRefTTL = 24 * time.Hour
+)
+
+// httpClient fetches features, the timeout keeps an unreachable registry from blocking the build.
+var httpClient = &http.Client{Timeout: 2 * time.Minute}
+
+// featureCacheDir returns the directory a downloaded feature is extracted to.
+func featureCacheDir(key string) (string, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(cacheDir, "devcli", "features", hex.EncodeToString(sum[:])), nil
+}
+
+// resolvedFeature is the cached result of fetching a feature reference.
+type resolvedFeature struct {
+	Dir      string    `json:"dir"`
+	Resolved time.Time `json:"resolved"`
+}
+
+// fetchFeature returns the directory of a remote feature. Resolved references are cached, so
+// the network is only used again after featureRefTTL, and never for references pinned by digest.
+// If fetching fails, e.g. when offline, the cached directory is used regardless of its age.
+func fetchFeature(source string, fetch func(string) (string, error)) (string, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return fetch(source)
+	}
+	refsFile := filepath.Join(cacheDir, "devcli", "feature-refs.json")
+	refs := map[string]resolvedFeature{}
+	if data, err := os.ReadFile(refsFile); err == nil {
+		if err := json.Unmarshal(data, &refs); err != nil {
+			logger.Debug().Err(err).Str("path", refsFile).Msg("ignoring invalid feature cache")
+			refs = map[string]resolvedFeature{}
+		}
+	}
+	cached, found := refs[source]
+	if found {
+		_, err := os.Stat(filepath.Join(cached.Dir, "devcontainer-feature.json"))
+		found = err == nil
+	}
+	if found && (strings.Contains(source, "@sha256:") || time.Since(cached.Resolved) < featureRefTTL) {
+		logger.Debug().Str("feature", source).Str("dir", cached.Dir).Msg("using cached feature")
+		return cached.Dir, nil
+	}
+	dir, err := fetch(source)
+	if err != nil {
+		if found {
+			logger.Warn().Err(err).Str("feature", source).Msg("could not fetch feature, using the cached version")
+			return cached.Dir, nil
+		}
+		return "", err
+	}
+	refs[source] = resolvedFeature{Dir: dir, Resolved: time.Now()}
+	data, err := json.Marshal(refs)
+	if err == nil {
+		// write to a temporary file first, so concurrent runs never read a partial cache
+		tmp := refsFile + fmt.Sprintf(".%d", os.Getpid())
+		if err = os.WriteFile(tmp, data, 0644); err == nil {
+			err = os.Rename(tmp, refsFile)
+		}
+	}
+	if err != nil {
+		logger.Debug().Err(err).Str("path", refsFile).Msg("could not save feature cache")
+	}
+	return dir, nil
+}
+
+// fetchTarballFeature downloads a feature tarball and extracts it into the cache.
+func fetchTarballFeature(source string) (string, error) {
+	dir, err := featureCacheDir(source)
+	if err != nil {
+		return "", err
+	}
+	logger.Debug().Str("feature", source).Str("dir", dir).Msg("downloading feature tarball")
+	resp, err := httpClient.Get(source)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("GET %s: %s", source, resp.Status)
+	}
+	if err := extractFeature(resp.Body, dir); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// fetchOCIFeature pulls a feature from an OCI registry, e.g. ghcr.io/devcontainers/features/node:1.
+// Only anonymous access is supported. Layers are cached by digest.
+func fetchOCIFeature(source string) (string, error) {
+	ref := featureBaseSource(source)
+	tag := "latest"
+	if strings.Contains(source, "@") {
+		tag = strings.SplitN(source, "@", 2)[1]
+	} else if ref != source {
+		tag = source[len(ref)+1:]
+	}
+	registry, repository, found := strings.Cut(ref, "/")
+	if !found {
+		return "", fmt.Errorf("invalid feature reference %q", source)
+	}
+
+	registryClient := &ociClient{registry: registry, repository: repository}
+	var manifest struct {
+		Layers []struct {
+			MediaType string `json:"mediaType"`
+			Digest    string `json:"digest"`
+		} `json:"layers"`
+	}
+	body, err := registryClient.get("/manifests/"+tag, ociManifestMediaType)
+	if err != nil {
+		return "", err
+	}
+	err = json.NewDecoder(body).Decode(&manifest)
+	body.Close()
+	if err != nil {
+		return "", fmt.Errorf("invalid manifest: %w", err)
+	}
+	for _, layer := range manifest.Layers {
+		if layer.MediaType != featureLayerType {
+			continue
+		}
+		dir, err := featureCacheDir(layer.Digest)
+		if err != nil {
+			return "", err
+		}
+		if _, err := os.Stat(filepath.Join(dir, "devcontainer-feature.json")); err == nil {
+			logger.Debug().Str("feature", source).Str("dir", dir).Msg("using cached feature")
+			return dir, nil
+		}
+		logger.Debug().Str("feature", source).Str("digest", layer.Digest).Msg("downloading feature layer")
+		blob, err := registryClient.get("/blobs/"+layer.Digest, "")
+		if err != nil {
+			return "", err
+		}
+		defer blob.Close()
+		if err := extractFeature(blob, dir); err != nil {
+			return "", err
+		}
+		return dir, nil
+	}
+	return "", fmt.Errorf("manifest of %s has no devcontainer feature layer", source)
+}
+
+type ociClient struct {
+	registry   string
+	repository string
+	token      string
+}
+
+// get requests a path below /v2/<repository>. If the registry requires a token, an
+// anonymous pull token is requested from the realm announced in WWW-Authenticate.
+func (c *ociClient) get(path string, accept string) (io.ReadCloser, error) {
+	u := "https://" + c.registry + "/v2/" + c.repository + path
+	for attempt := 0; attempt < 2; attempt++ {
+		req, err := http.NewRequest("GET", u, nil)
+		if err != nil {
+			return nil, err
+		}
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		if c.token != "" {
+			req.Header.Set("Authorization", "Bearer "+c.token)
+		}
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode == http.StatusUnauthorized && c.token == "" {
+			challenge := resp.Header.Get("WWW-Authenticate")
+			resp.Body.Close()
+			if err := c.fetchToken(challenge); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("GET %s: %s", u, resp.Status)
+		}
+		return resp.Body, nil
+	}
+	return nil, fmt.Errorf("GET %s: unauthorized", u)
+}
+
+func (c *ociClient) fetchToken(challenge string) error {
+	scheme, params, _ := strings.Cut(challenge, " ")
+	if !strings.EqualFold(scheme, "Bearer") {
+		return fmt.Errorf("unsupported registry authentication %q", challenge)
+	}
+	values := map[string]string{}
+	for _, param := range strings.Split(params, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+		values[key] = strings.Trim(value, `"`)
+	}
+	query := url.Values{}
+	if values["service"] != "" {
+		query.Set("service", values["service"])
+	}
+	query.Set("scope", "repository:"+c.repository+":pull")
+	resp, err := httpClient.Get(values["realm"] + "?" + query.Encode())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("could not get registry token: %s", resp.Status)
+	}
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return err
+	}
+	c.token = token.Token
+	if c.token == "" {
+		c.token = token.AccessToken
+	}
+	return nil
+}
+
+// extractFeature extracts a plain or gzipped tar archive into dir, replacing previous content.
+// The archive is extracted into a temporary directory first, so a failed download keeps the
+// previous content.
+func extractFeature(r io.Reader, dir string) error {
+	if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.MkdirTemp(filepath.Dir(dir), filepath.Base(dir)+".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	if err := extractArchive(r, tmp); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp, 0755); err != nil {
+		return err
+	}
+	// move the previous content aside, a directory can not be replaced by a rename
+	old := tmp + ".old"
+	if err := os.Rename(dir, old); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	defer os.RemoveAll(old)
+	return os.Rename(tmp, dir)
+}
+
+// extractArchive extracts a plain or gzipped tar archive into the existing directory dir.
+func extractArchive(r io.Reader, dir string) error {
+	br := bufio.NewReader(r)
+	var archive io.Reader = br
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		archive = gz
+	}
+	tr := tar.NewReader(archive)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dir, filepath.Clean("/"+header.Name))
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			f, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, os.FileMode(header.Mode)&0777)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(f, tr)
+			f.Close()
+			if err != nil {
+				return err
+			}
+		default:
+			logger.Debug().Str("file", header.Name).Msg("skipping unsupported file type in feature archive")
+		}
+	}
+}
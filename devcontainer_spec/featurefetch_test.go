@@ -0,0 +1,105 @@
+package devcontainerspec
+
+import (
+	"archive/tar"
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func featureTarball(t *testing.T, id string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for name, content := range map[string]string{
+		"devcontainer-feature.json": `{"id": "` + id + `"}`,
+		"install.sh":                "#!/bin/sh\n",
+	} {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0755, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(content))
+	}
+	tw.Close()
+	return buf.Bytes()
+}
+
+func TestFetchFeatureUsesCache(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Write(featureTarball(t, "test"))
+	}))
+	defer server.Close()
+	source := server.URL + "/feature.tgz"
+
+	dir, err := fetchFeature(source, fetchTarballFeature)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "install.sh")); err != nil {
+		t.Fatalf("feature is not extracted: %v", err)
+	}
+	cached, err := fetchFeature(source, fetchTarballFeature)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cached != dir || requests != 1 {
+		t.Errorf("resolved reference is not cached, %d requests", requests)
+	}
+}
+
+func TestFetchFeatureOffline(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(featureTarball(t, "test"))
+	}))
+	source := server.URL + "/feature.tgz"
+	dir, err := fetchFeature(source, fetchTarballFeature)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Close()
+
+	// an expired reference is fetched again, the cache is used if the registry is not reachable
+	offline := func(source string) (string, error) {
+		return "", os.ErrDeadlineExceeded
+	}
+	refsFile := filepath.Join(os.Getenv("XDG_CACHE_HOME"), "devcli", "feature-refs.json")
+	if err := os.WriteFile(refsFile, []byte(`{"`+source+`": {"dir": "`+dir+`", "resolved": "2000-01-01T00:00:00Z"}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cached, err := fetchFeature(source, offline)
+	if err != nil {
+		t.Fatalf("cached feature is not used offline: %v", err)
+	}
+	if cached != dir {
+		t.Errorf("expected %s, got %s", dir, cached)
+	}
+	if _, err := fetchFeature(server.URL+"/other.tgz", offline); err == nil {
+		t.Error("expected an error for an uncached feature")
+	}
+}
+
+func TestExtractFeatureKeepsContentOnError(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "feature")
+	if err := extractFeature(bytes.NewReader(featureTarball(t, "old")), dir); err != nil {
+		t.Fatal(err)
+	}
+	truncated := featureTarball(t, "new")
+	if err := extractFeature(bytes.NewReader(truncated[:520]), dir); err == nil {
+		t.Fatal("expected an error for a truncated archive")
+	}
+	content, err := os.ReadFile(filepath.Join(dir, "devcontainer-feature.json"))
+	if err != nil || string(content) != `{"id": "old"}` {
+		t.Errorf("previous content is lost: %q %v", content, err)
+	}
+	entries, _ := os.ReadDir(filepath.Dir(dir))
+	if len(entries) != 1 {
+		t.Errorf("temporary directories are left behind: %v", entries)
+	}
+}
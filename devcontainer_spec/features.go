@@ -0,0 +1,456 @@
+package devcontainerspec
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// FeaturesContextDir is the directory inside the build context the features are copied to.
+	FeaturesContextDir = ".devcli-features"
+	// featuresBaseStage is the name of the build stage the features are installed on top of.
+	featuresBaseStage = "devcli_features_base"
+	featureInstallDir = "/tmp/devcli-features"
+	// FeaturesBaseUserArg is the build argument with the user of the base image, if it can not be
+	// determined from the Dockerfile. The runtime sets it from the image config.
+	FeaturesBaseUserArg = "DEVCLI_BASE_USER"
+)
+
+// Feature is a resolved devcontainer feature with its options applied.
+type Feature struct {
+	ID     string
+	Source string
+	// Options are the environment variables passed to install.sh
+	Options       map[string]string
+	InstallsAfter []string
+	ContainerEnv  map[string]string
+	// ContentHash covers all files of the feature, so changing a feature triggers a rebuild
+	ContentHash string
+	// ContextName is the directory below FeaturesContextDir in the build context
+	ContextName string
+	// RunArgs and Mounts are required by the feature for the container
+	RunArgs []string
+	Mounts  []string
+	// Dir is the feature directory on the host
+	Dir string `json:"-"`
+}
+
+// featureMetadata is the content of a devcontainer-feature.json file.
+type featureMetadata struct {
+	ID      string `json:"id"`
+	Version string `json:"version"`
+	Options map[string]struct {
+		Type    string `json:"type"`
+		Default any    `json:"default"`
+	} `json:"options"`
+	InstallsAfter []string          `json:"installsAfter"`
+	ContainerEnv  map[string]string `json:"containerEnv"`
+	Privileged    bool              `json:"privileged"`
+	Init          bool              `json:"init"`
+	CapAdd        []string          `json:"capAdd"`
+	SecurityOpt   []string          `json:"securityOpt"`
+	Mounts        []struct {
+		Type   string `json:"type"`
+		Source string `json:"source"`
+		Target string `json:"target"`
+	} `json:"mounts"`
+}
+
+// resolveFeature fetches the feature referenced by source, reads its metadata and applies the
+// user options on top of the option defaults. Local features are resolved relative to configDir.
+func resolveFeature(source string, userOptions json.RawMessage, configDir string) (Feature, error) {
+	var dir string
+	var err error
+	switch {
+	case strings.HasPrefix(source, "./") || strings.HasPrefix(source, "../"):
+		dir = filepath.Join(configDir, source)
+	case strings.HasPrefix(source, "https://") || strings.HasPrefix(source, "http://"):
+		dir, err = fetchFeature(source, fetchTarballFeature)
+	default:
+		dir, err = fetchFeature(source, fetchOCIFeature)
+	}
+	if err != nil {
+		return Feature{}, fmt.Errorf("could not fetch feature %s: %w", source, err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "devcontainer-feature.json"))
+	if err != nil {
+		return Feature{}, fmt.Errorf("feature %s: %w", source, err)
+	}
+	var metadata featureMetadata
+	if err := unmarshalJSONC(filepath.Join(dir, "devcontainer-feature.json"), data, &metadata); err != nil {
+		return Feature{}, fmt.Errorf("feature %s: invalid devcontainer-feature.json: %w", source, err)
+	}
+	if metadata.ID == "" {
+		return Feature{}, fmt.Errorf("feature %s: devcontainer-feature.json has no id", source)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "install.sh")); err != nil {
+		return Feature{}, fmt.Errorf("feature %s: %w", source, err)
+	}
+
+	options, err := parseFeatureOptions(userOptions)
+	if err != nil {
+		return Feature{}, fmt.Errorf("feature %s: %w", source, err)
+	}
+	env := map[string]string{}
+	for name, option := range metadata.Options {
+		if option.Default != nil {
+			env[featureOptionEnvName(name)] = featureOptionValue(option.Default)
+		}
+	}
+	for name, value := range options {
+		if _, known := metadata.Options[name]; !known {
+			logger.Warn().Str("feature", source).Str("option", name).Msg("unknown feature option")
+		}
+		env[featureOptionEnvName(name)] = value
+	}
+
+	contentHash, err := hashDirectory(dir)
+	if err != nil {
+		return Feature{}, err
+	}
+	runArgs, mounts := featureRunArgs(metadata)
+	return Feature{
+		ID:            metadata.ID,
+		Source:        source,
+		Options:       env,
+		InstallsAfter: metadata.InstallsAfter,
+		ContainerEnv:  metadata.ContainerEnv,
+		ContentHash:   contentHash,
+		RunArgs:       runArgs,
+		Mounts:        mounts,
+		Dir:           dir,
+	}, nil
+}
+
+// parseFeatureOptions accepts the forms allowed for a feature value in devcontainer.json:
+// an object with options, a string as shorthand for the "version" option, or a boolean.
+func parseFeatureOptions(raw json.RawMessage) (map[string]string, error) {
+	options := map[string]string{}
+	if len(raw) == 0 {
+		return options, nil
+	}
+	var value any
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return nil, err
+	}
+	switch v := value.(type) {
+	case map[string]any:
+		for name, option := range v {
+			options[name] = featureOptionValue(option)
+		}
+	case string:
+		options["version"] = v
+	case bool, nil:
+		// no options given, the defaults are used
+	default:
+		return nil, fmt.Errorf("invalid feature options %s", string(raw))
+	}
+	return options, nil
+}
+
+func featureOptionValue(value any) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		data, _ := json.Marshal(v)
+		return string(data)
+	}
+}
+
+var nonEnvCharacters = regexp.MustCompile(`[^A-Z0-9_]`)
+
+// featureOptionEnvName converts an option name into the environment variable name used by install.sh.
+func featureOptionEnvName(name string) string {
+	env := nonEnvCharacters.ReplaceAllString(strings.ToUpper(name), "_")
+	if env != "" && env[0] >= '0' && env[0] <= '9' {
+		env = "_" + env
+	}
+	return env
+}
+
+// featureRunArgs converts the container properties of a feature into runArgs and mounts.
+func featureRunArgs(metadata featureMetadata) ([]string, []string) {
+	runArgs := []string{}
+	if metadata.Privileged {
+		runArgs = append(runArgs, "--privileged")
+	}
+	if metadata.Init {
+		runArgs = append(runArgs, "--init")
+	}
+	for _, capAdd := range metadata.CapAdd {
+		runArgs = append(runArgs, "--cap-add="+capAdd)
+	}
+	for _, securityOpt := range metadata.SecurityOpt {
+		runArgs = append(runArgs, "--security-opt="+securityOpt)
+	}
+	mounts := []string{}
+	for _, m := range metadata.Mounts {
+		mounts = append(mounts, fmt.Sprintf("type=%s,source=%s,target=%s", m.Type, m.Source, m.Target))
+	}
+	return runArgs, mounts
+}
+
+// applyFeatures orders the features and layers their installation on top of the Dockerfile
+// or image. The image is only the base of the generated Dockerfile afterwards.
+func (devc *Devcontainer) applyFeatures() error {
+	if len(devc.Config.Features) == 0 {
+		return nil
+	}
+	sorted, err := sortFeatures(devc.Config.Features, devc.Config.OverrideFeatureInstallOrder)
+	if err != nil {
+		return err
+	}
+	for i := range sorted {
+		sorted[i].ContextName = fmt.Sprintf("%d_%s", i, filepath.Base(sorted[i].ID))
+		devc.Config.RunArgs = append(devc.Config.RunArgs, sorted[i].RunArgs...)
+		devc.Config.Mounts = append(devc.Config.Mounts, sorted[i].Mounts...)
+		logger.Debug().Str("feature", sorted[i].Source).Interface("options", sorted[i].Options).Msg("install feature")
+	}
+	baseUser, baseImage := dockerfileUser(devc.Config.DockerFileContent, devc.Config.Image)
+	dockerfile, err := generateFeaturesDockerfile(devc.Config.DockerFileContent, devc.Config.Image, sorted, baseUser)
+	if err != nil {
+		return err
+	}
+	if baseUser == "" {
+		devc.Config.FeaturesBaseImage = baseImage
+	}
+	devc.Config.Features = sorted
+	devc.Config.DockerFileContent = dockerfile
+	devc.Config.Image = ""
+	return nil
+}
+
+// sortFeatures orders the features so that every feature is installed after the features
+// listed in its installsAfter. Features listed in overrideOrder come first in that order,
+// otherwise the order is alphabetical by source.
+func sortFeatures(features []Feature, overrideOrder []string) ([]Feature, error) {
+	priority := func(f Feature) int {
+		for i, id := range overrideOrder {
+			if id == f.ID || id == f.Source || id == featureBaseSource(f.Source) {
+				return i
+			}
+		}
+		return len(overrideOrder)
+	}
+	remaining := append([]Feature{}, features...)
+	sort.SliceStable(remaining, func(i, j int) bool {
+		pi, pj := priority(remaining[i]), priority(remaining[j])
+		if pi != pj {
+			return pi < pj
+		}
+		return remaining[i].Source < remaining[j].Source
+	})
+	installed := map[string]bool{}
+	sorted := []Feature{}
+	for len(remaining) > 0 {
+		progress := false
+		for i, feature := range remaining {
+			ready := true
+			for _, after := range feature.InstallsAfter {
+				if !installed[after] && containsFeature(remaining, after) {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				sorted = append(sorted, feature)
+				installed[feature.ID] = true
+				installed[featureBaseSource(feature.Source)] = true
+				remaining = append(remaining[:i], remaining[i+1:]...)
+				progress = true
+				break
+			}
+		}
+		if !progress {
+			ids := []string{}
+			for _, feature := range remaining {
+				ids = append(ids, feature.ID)
+			}
+			return nil, fmt.Errorf("cyclic installsAfter dependency between features %v", ids)
+		}
+	}
+	return sorted, nil
+}
+
+// featureBaseSource strips the version from an OCI feature reference.
+func featureBaseSource(source string) string {
+	if base, _, found := strings.Cut(source, "@"); found {
+		return base
+	}
+	lastSlash := strings.LastIndex(source, "/")
+	if lastColon := strings.LastIndex(source, ":"); lastColon > lastSlash {
+		return source[:lastColon]
+	}
+	return source
+}
+
+func containsFeature(features []Feature, id string) bool {
+	for _, feature := range features {
+		if feature.ID == id || featureBaseSource(feature.Source) == id {
+			return true
+		}
+	}
+	return false
+}
+
+var fromInstruction = regexp.MustCompile(`(?im)^[ \t]*FROM[ \t]+(.*)$`)
+
+// dockerfileUser returns the user the last stage of the Dockerfile, or the image, runs as. If
+// the user is not set in the Dockerfile, the image the stage is based on is returned instead.
+func dockerfileUser(dockerfile string, image string) (string, string) {
+	if dockerfile == "" {
+		return "", image
+	}
+	type stage struct {
+		from string
+		user string
+	}
+	stages := []stage{}
+	names := map[string]int{}
+	for _, instruction := range dockerfileInstructions(dockerfile) {
+		keyword, rest, _ := strings.Cut(instruction, " ")
+		switch strings.ToUpper(keyword) {
+		case "FROM":
+			fields := []string{}
+			for _, field := range strings.Fields(rest) {
+				if !strings.HasPrefix(field, "--") {
+					fields = append(fields, field)
+				}
+			}
+			if len(fields) == 0 {
+				continue
+			}
+			if len(fields) >= 3 && strings.EqualFold(fields[1], "AS") {
+				names[strings.ToLower(fields[2])] = len(stages)
+			}
+			stages = append(stages, stage{from: fields[0]})
+		case "USER":
+			if len(stages) > 0 {
+				stages[len(stages)-1].user = strings.TrimSpace(rest)
+			}
+		}
+	}
+	if len(stages) == 0 {
+		return "", ""
+	}
+	// follow the stages the last stage is based on until a user is set
+	current := stages[len(stages)-1]
+	for seen := 0; seen < len(stages); seen++ {
+		if current.user != "" {
+			return current.user, ""
+		}
+		index, ok := names[strings.ToLower(current.from)]
+		if !ok {
+			break
+		}
+		current = stages[index]
+	}
+	return "", current.from
+}
+
+// generateFeaturesDockerfile layers the installation of the features on top of the Dockerfile
+// or image. The last stage of the Dockerfile is named, so the features can be installed on it.
+// The features are installed as root, afterwards the user is reset to baseUser. Without
+// baseUser it is taken from the build argument FeaturesBaseUserArg.
+func generateFeaturesDockerfile(dockerfile string, image string, features []Feature, baseUser string) (string, error) {
+	var sb strings.Builder
+	if dockerfile != "" {
+		matches := fromInstruction.FindAllStringSubmatchIndex(dockerfile, -1)
+		if len(matches) == 0 {
+			return "", fmt.Errorf("dockerfile has no FROM instruction")
+		}
+		last := matches[len(matches)-1]
+		fields := strings.Fields(dockerfile[last[2]:last[3]])
+		baseStage := featuresBaseStage
+		if len(fields) >= 3 && strings.EqualFold(fields[len(fields)-2], "AS") {
+			baseStage = fields[len(fields)-1]
+			sb.WriteString(dockerfile)
+		} else {
+			sb.WriteString(dockerfile[:last[3]])
+			sb.WriteString(" AS " + baseStage)
+			sb.WriteString(dockerfile[last[3]:])
+		}
+		sb.WriteString("\n\nFROM " + baseStage + "\n")
+	} else {
+		sb.WriteString("FROM " + image + "\n")
+	}
+	sb.WriteString("USER root\n")
+	for _, feature := range features {
+		target := featureInstallDir + "/" + feature.ContextName
+		sb.WriteString(fmt.Sprintf("\n# feature %s\n", feature.Source))
+		sb.WriteString(fmt.Sprintf("COPY %s/%s %s\n", FeaturesContextDir, feature.ContextName, target))
+		names := make([]string, 0, len(feature.Options))
+		for name := range feature.Options {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		env := ""
+		for _, name := range names {
+			env += name + "=" + shellQuote(feature.Options[name]) + " "
+		}
+		// the sources are removed again, so the image does not keep them
+		sb.WriteString(fmt.Sprintf("RUN cd %s && chmod +x install.sh && %s./install.sh && cd / && rm -rf %s\n", target, env, target))
+		envNames := make([]string, 0, len(feature.ContainerEnv))
+		for name := range feature.ContainerEnv {
+			envNames = append(envNames, name)
+		}
+		sort.Strings(envNames)
+		for _, name := range envNames {
+			sb.WriteString(fmt.Sprintf("ENV %s=%s\n", name, strconv.Quote(feature.ContainerEnv[name])))
+		}
+	}
+	sb.WriteString("\n")
+	if baseUser == "" {
+		// an image without user runs as root
+		sb.WriteString(fmt.Sprintf("ARG %s=root\n", FeaturesBaseUserArg))
+		baseUser = "$" + FeaturesBaseUserArg
+	}
+	sb.WriteString("USER " + baseUser + "\n")
+	return sb.String(), nil
+}
+
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+// hashDirectory hashes the relative paths, modes and contents of all files below dir.
+func hashDirectory(dir string) (string, error) {
+	h := sha256.New()
+	err := filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(dir, file)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(h, "%s %o\n", filepath.ToSlash(relPath), info.Mode())
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(h, f)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
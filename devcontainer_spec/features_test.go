@@ -0,0 +1,65 @@
+package devcontainerspec
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDockerfileUser(t *testing.T) {
+	tests := []struct {
+		name       string
+		dockerfile string
+		image      string
+		user       string
+		baseImage  string
+	}{
+		{name: "image", image: "ubuntu", baseImage: "ubuntu"},
+		{name: "user in last stage", dockerfile: "FROM ubuntu\nUSER vscode\n", user: "vscode"},
+		{name: "last user wins", dockerfile: "FROM ubuntu\nUSER root\nRUN true\nUSER dev\n", user: "dev"},
+		{name: "no user", dockerfile: "FROM --platform=linux/amd64 ubuntu:24.04\nRUN true\n", baseImage: "ubuntu:24.04"},
+		{name: "user of base stage", dockerfile: "FROM ubuntu AS base\nUSER dev\nFROM base\nRUN true\n", user: "dev"},
+		{name: "user of other stage is ignored", dockerfile: "FROM golang AS build\nUSER dev\nFROM debian AS final\nRUN true\n", baseImage: "debian"},
+		{name: "image of base stage", dockerfile: "FROM node AS base\nFROM base\n", baseImage: "node"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			user, baseImage := dockerfileUser(test.dockerfile, test.image)
+			if user != test.user || baseImage != test.baseImage {
+				t.Errorf("expected (%q, %q), got (%q, %q)", test.user, test.baseImage, user, baseImage)
+			}
+		})
+	}
+}
+
+func TestGenerateFeaturesDockerfileResetsUser(t *testing.T) {
+	features := []Feature{{Source: "./node", ContextName: "0_node"}}
+	dockerfile, err := generateFeaturesDockerfile("FROM ubuntu\nUSER dev\n", "", features, "dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(dockerfile, "\nUSER dev\n") {
+		t.Errorf("user is not reset:\n%s", dockerfile)
+	}
+	dockerfile, err = generateFeaturesDockerfile("", "ubuntu", features, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(dockerfile, "\nARG "+FeaturesBaseUserArg+"=root\nUSER $"+FeaturesBaseUserArg+"\n") {
+		t.Errorf("user is not reset from the build argument:\n%s", dockerfile)
+	}
+	if strings.Index(dockerfile, "USER root") > strings.Index(dockerfile, "install.sh") {
+		t.Errorf("features are not installed as root:\n%s", dockerfile)
+	}
+}
+
+func TestGenerateFeaturesDockerfileRemovesSources(t *testing.T) {
+	features := []Feature{{Source: "./node", ContextName: "0_node", Options: map[string]string{"VERSION": "20"}}}
+	dockerfile, err := generateFeaturesDockerfile("", "ubuntu", features, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "RUN cd /tmp/devcli-features/0_node && chmod +x install.sh && VERSION='20' ./install.sh && cd / && rm -rf /tmp/devcli-features/0_node\n"
+	if !strings.Contains(dockerfile, want) {
+		t.Errorf("feature sources are not removed:\n%s", dockerfile)
+	}
+}
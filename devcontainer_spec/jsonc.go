@@ -0,0 +1,128 @@
+package devcontainerspec
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// SyntaxError is an error in a devcontainer.json file with its position.
+type SyntaxError struct {
+	Path   string
+	Line   int
+	Column int
+	Msg    string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("%s:%d:%d: %s", e.Path, e.Line, e.Column, e.Msg)
+}
+
+func newSyntaxError(path string, data []byte, offset int, msg string) *SyntaxError {
+	line, column := lineAndColumn(data, offset)
+	return &SyntaxError{Path: path, Line: line, Column: column, Msg: msg}
+}
+
+// lineAndColumn converts a byte offset into a 1-based line and column.
+func lineAndColumn(data []byte, offset int) (int, int) {
+	if offset > len(data) {
+		offset = len(data)
+	}
+	line := 1 + bytes.Count(data[:offset], []byte("\n"))
+	column := offset + 1
+	if i := bytes.LastIndexByte(data[:offset], '\n'); i >= 0 {
+		column = offset - i
+	}
+	return line, column
+}
+
+// stripJSONC converts JSON with comments into plain JSON. Comments and trailing commas outside
+// of string literals are replaced with spaces, so byte offsets stay the same as in the input
+// and errors of the JSON decoder can be mapped back to the original file.
+func stripJSONC(path string, data []byte) ([]byte, error) {
+	out := bytes.Clone(data)
+	blank := func(from, to int) {
+		for i := from; i < to; i++ {
+			if out[i] != '\n' && out[i] != '\r' {
+				out[i] = ' '
+			}
+		}
+	}
+	// pendingComma is the position of a comma that is removed if only whitespace and
+	// comments follow before the closing bracket
+	pendingComma := -1
+	for i := 0; i < len(data); i++ {
+		switch ch := data[i]; {
+		case ch == '"':
+			pendingComma = -1
+			start := i
+			for i++; i < len(data) && data[i] != '"'; i++ {
+				if data[i] == '\\' {
+					i++
+				} else if data[i] == '\n' {
+					return nil, newSyntaxError(path, data, start, "unterminated string")
+				}
+			}
+			if i >= len(data) {
+				return nil, newSyntaxError(path, data, start, "unterminated string")
+			}
+		case ch == '/' && i+1 < len(data) && data[i+1] == '/':
+			start := i
+			for i < len(data) && data[i] != '\n' {
+				i++
+			}
+			blank(start, i)
+		case ch == '/' && i+1 < len(data) && data[i+1] == '*':
+			start := i
+			end := bytes.Index(data[i+2:], []byte("*/"))
+			if end < 0 {
+				return nil, newSyntaxError(path, data, start, "unterminated comment")
+			}
+			i += 2 + end + 1
+			blank(start, i+1)
+		case ch == ',':
+			pendingComma = i
+		case ch == '}' || ch == ']':
+			if pendingComma >= 0 {
+				out[pendingComma] = ' '
+			}
+			pendingComma = -1
+		case ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r':
+			// whitespace keeps a pending comma
+		default:
+			pendingComma = -1
+		}
+	}
+	return out, nil
+}
+
+// unmarshalJSONC decodes JSON with comments into v and reports errors with their position.
+func unmarshalJSONC(path string, data []byte, v any) error {
+	stripped, err := stripJSONC(path, data)
+	if err != nil {
+		return err
+	}
+	return jsonError(path, data, json.Unmarshal(stripped, v))
+}
+
+// jsonError adds the position in data to errors of the JSON decoder.
+func jsonError(path string, data []byte, err error) error {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case err == nil:
+		return nil
+	case errors.As(err, &syntaxErr):
+		// the offset points behind the byte that caused the error
+		return newSyntaxError(path, data, max(int(syntaxErr.Offset)-1, 0), syntaxErr.Error())
+	case errors.As(err, &typeErr):
+		msg := fmt.Sprintf("cannot use %s as %s", typeErr.Value, typeErr.Type)
+		if typeErr.Field != "" {
+			msg = fmt.Sprintf("%s for field %q", msg, typeErr.Field)
+		}
+		return newSyntaxError(path, data, int(typeErr.Offset), msg)
+	default:
+		return fmt.Errorf("%s: %w", path, err)
+	}
+}
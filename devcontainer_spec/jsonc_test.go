@@ -0,0 +1,84 @@
+package devcontainerspec
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestStripJSONC(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    string
+		wantErr string
+	}{
+		{name: "line comment", input: "{\n  // comment\n  \"a\": 1 // trailing\n}", want: `{"a": 1}`},
+		{name: "block comment", input: `{/* one */ "a": /* two
+			lines */ 1}`, want: `{"a": 1}`},
+		{name: "line comment in string", input: `{"url": "https://example.com//path"}`, want: `{"url": "https://example.com//path"}`},
+		{name: "block comment in string", input: `{"glob": "src/*/*.go /* keep */"}`, want: `{"glob": "src/*/*.go /* keep */"}`},
+		{name: "trailing comma in string", input: `{"a": "x,]", "b": "y,}"}`, want: `{"a": "x,]", "b": "y,}"}`},
+		{name: "escaped quote", input: `{"cmd": "echo \"// not a comment\",}", "b": 1}`, want: `{"cmd": "echo \"// not a comment\",}", "b": 1}`},
+		{name: "escaped backslash before quote", input: `{"path": "C:\\", "b": "//"}`, want: `{"path": "C:\\", "b": "//"}`},
+		{name: "trailing comma in object", input: "{\"a\": 1,\n}", want: `{"a": 1}`},
+		{name: "trailing comma in array", input: `{"a": [1, 2, ]}`, want: `{"a": [1, 2]}`},
+		{name: "trailing comma before comment", input: "{\"a\": [1, // last\n]}", want: `{"a": [1]}`},
+		{name: "unterminated string", input: "{\n  \"a\": \"open\n}", wantErr: "devcontainer.json:2:8: unterminated string"},
+		{name: "unterminated string at end", input: `{"a": "open`, wantErr: "devcontainer.json:1:7: unterminated string"},
+		{name: "unterminated block comment", input: "{\n  /* open\n}", wantErr: "devcontainer.json:2:3: unterminated comment"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stripped, err := stripJSONC("devcontainer.json", []byte(test.input))
+			if test.wantErr != "" {
+				if err == nil || err.Error() != test.wantErr {
+					t.Fatalf("expected error %q, got %v", test.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(stripped) != len(test.input) {
+				t.Errorf("offsets are not kept: %q", stripped)
+			}
+			var got, want any
+			if err := json.Unmarshal(stripped, &got); err != nil {
+				t.Fatalf("stripped JSON is invalid: %v\n%s", err, stripped)
+			}
+			if err := json.Unmarshal([]byte(test.want), &want); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("expected %v, got %v", want, got)
+			}
+		})
+	}
+}
+
+func TestUnmarshalJSONCErrorPosition(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		line   int
+		column int
+	}{
+		{name: "syntax error after comment", input: "{\n  // comment\n  \"a\": 1\n  \"b\": 2\n}", line: 4, column: 3},
+		{name: "type error", input: "{\n  /* image */\n  \"image\": 42\n}", line: 3, column: 14},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var devj DevcontainerJson
+			err := unmarshalJSONC("/ws/.devcontainer/devcontainer.json", []byte(test.input), &devj)
+			var syntaxErr *SyntaxError
+			if !errors.As(err, &syntaxErr) {
+				t.Fatalf("expected a SyntaxError, got %v", err)
+			}
+			if syntaxErr.Path != "/ws/.devcontainer/devcontainer.json" || syntaxErr.Line != test.line || syntaxErr.Column != test.column {
+				t.Errorf("expected position %d:%d, got %s", test.line, test.column, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package devcontainerspec
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// LifecycleCommand is a lifecycle command in one of the forms allowed by the spec:
+// a string that is run by a shell, an array that is run without a shell, or an object
+// of named commands that are run in parallel.
+type LifecycleCommand struct {
+	Shell    string                      `json:",omitempty"`
+	Args     []string                    `json:",omitempty"`
+	Parallel map[string]LifecycleCommand `json:",omitempty"`
+}
+
+func (c *LifecycleCommand) UnmarshalJSON(data []byte) error {
+	var value any
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	switch v := value.(type) {
+	case nil:
+		*c = LifecycleCommand{}
+	case string:
+		*c = LifecycleCommand{Shell: v}
+	case []any:
+		args := []string{}
+		if err := json.Unmarshal(data, &args); err != nil {
+			return fmt.Errorf("lifecycle command array must only contain strings: %w", err)
+		}
+		*c = LifecycleCommand{Args: args}
+	case map[string]any:
+		entries := map[string]LifecycleCommand{}
+		if err := json.Unmarshal(data, &entries); err != nil {
+			return err
+		}
+		for name, entry := range entries {
+			if entry.Parallel != nil {
+				return fmt.Errorf("parallel lifecycle command %q must be a string or an array", name)
+			}
+		}
+		*c = LifecycleCommand{Parallel: entries}
+	default:
+		return fmt.Errorf("lifecycle command must be a string, an array or an object, got %s", string(data))
+	}
+	return nil
+}
+
+// IsEmpty reports whether there is nothing to run.
+func (c LifecycleCommand) IsEmpty() bool {
+	return c.Shell == "" && len(c.Args) == 0 && len(c.Parallel) == 0
+}
+
+// ParallelNames returns the names of the parallel commands in a stable order.
+func (c LifecycleCommand) ParallelNames() []string {
+	names := make([]string, 0, len(c.Parallel))
+	for name := range c.Parallel {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (c LifecycleCommand) String() string {
+	switch {
+	case c.Shell != "":
+		return c.Shell
+	case len(c.Args) > 0:
+		return strings.Join(c.Args, " ")
+	default:
+		parts := []string{}
+		for _, name := range c.ParallelNames() {
+			parts = append(parts, name+": "+c.Parallel[name].String())
+		}
+		return strings.Join(parts, "; ")
+	}
+}
+
+// substitute returns a copy of the command with fn applied to every string.
+func (c LifecycleCommand) substitute(fn func(string) string) LifecycleCommand {
+	result := LifecycleCommand{Shell: fn(c.Shell)}
+	for _, arg := range c.Args {
+		result.Args = append(result.Args, fn(arg))
+	}
+	if c.Parallel != nil {
+		result.Parallel = map[string]LifecycleCommand{}
+		for name, entry := range c.Parallel {
+			result.Parallel[name] = entry.substitute(fn)
+		}
+	}
+	return result
+}
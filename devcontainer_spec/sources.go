@@ -0,0 +1,88 @@
+package devcontainerspec
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"reflect"
+	"sort"
+)
+
+// configFields returns the JSON encoded value of every field of the config by field name.
+// Unlike the JSON encoding of the config, which is used for the hash, it includes the fields
+// that do not influence the container.
+func configFields(config DevcontainerConfig) (map[string]json.RawMessage, error) {
+	fields := map[string]json.RawMessage{}
+	value := reflect.ValueOf(config)
+	for i := 0; i < value.NumField(); i++ {
+		data, err := json.Marshal(value.Field(i).Interface())
+		if err != nil {
+			return nil, err
+		}
+		fields[value.Type().Field(i).Name] = data
+	}
+	return fields, nil
+}
+
+// mergeFrom merges devj, which was read from the file of layer, and records the file as source
+// of all fields it changed.
+func (devc *Devcontainer) mergeFrom(devj DevcontainerJson, layer configLayer) error {
+	// the fields are encoded before the merge, because maps are merged in place
+	before, err := configFields(devc.Config)
+	if err != nil {
+		return err
+	}
+	if err := devc.Merge(devj); err != nil {
+		return err
+	}
+	after, err := configFields(devc.Config)
+	if err != nil {
+		return err
+	}
+	if devc.Sources == nil {
+		devc.Sources = map[string][]string{}
+	}
+	changed := []string{}
+	for name, value := range after {
+		if !bytes.Equal(before[name], value) {
+			devc.Sources[name] = append(devc.Sources[name], layer.file)
+			changed = append(changed, name)
+		}
+	}
+	sort.Strings(changed)
+	logger.Debug().Str("layer", layer.name).Str("file", layer.file).Strs("fields", changed).Msg("merged config layer")
+	return nil
+}
+
+// sourcedValue is a config value annotated with the files that set it.
+type sourcedValue struct {
+	Value   json.RawMessage `json:"value"`
+	Sources []string        `json:"sources,omitempty"`
+}
+
+// PrintConfig writes the effective config with the computed names as JSON. With sources every
+// value lists the files that set it, values without sources are defaults.
+func PrintConfig(w io.Writer, devc Devcontainer, sources bool) error {
+	fields, err := configFields(devc.Config)
+	if err != nil {
+		return err
+	}
+	var config any = fields
+	if sources {
+		sourced := map[string]sourcedValue{}
+		for name, value := range fields {
+			sourced[name] = sourcedValue{Value: value, Sources: devc.Sources[name]}
+		}
+		config = sourced
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(struct {
+		ConfigName    string `json:"configName,omitempty"`
+		ConfigPath    string `json:"configPath"`
+		Config        any    `json:"config"`
+		Hash          string `json:"hash"`
+		ImageName     string `json:"imageName"`
+		ContainerName string `json:"containerName"`
+	}{devc.ConfigName, devc.ConfigPath, config, devc.Hash, devc.GetImageName(), devc.GetContainerName()})
+}
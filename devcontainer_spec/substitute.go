@@ -0,0 +1,189 @@
+package devcontainerspec
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+var variablePattern = regexp.MustCompile(`\$\{([^}]+)\}`)
+
+// substitutionContext holds the values of the variables of one devcontainer.json file.
+type substitutionContext struct {
+	file                 string
+	localWorkspaceFolder string
+	devcontainerID       string
+}
+
+func newSubstitutionContext(file string, workspace string) substitutionContext {
+	return substitutionContext{
+		file:                 file,
+		localWorkspaceFolder: workspace,
+		devcontainerID:       devcontainerID(workspace),
+	}
+}
+
+// devcontainerID is a stable id for the devcontainer of a workspace, which does not change
+// when the config changes. It is encoded in base 32 like in the reference implementation.
+func devcontainerID(workspace string) string {
+	sum := sha256.Sum256([]byte(workspace))
+	id := new(big.Int).SetBytes(sum[:]).Text(32)
+	return strings.Repeat("0", 52-len(id)) + id
+}
+
+// substitute replaces the variables in all strings of a parsed JSON value.
+// The location is the JSON path of value and is used for warnings.
+func (ctx substitutionContext) substitute(value any, location string) any {
+	switch v := value.(type) {
+	case string:
+		return ctx.substituteString(v, location)
+	case []any:
+		for i := range v {
+			v[i] = ctx.substitute(v[i], fmt.Sprintf("%s[%d]", location, i))
+		}
+	case map[string]any:
+		for key := range v {
+			v[key] = ctx.substitute(v[key], location+"."+key)
+		}
+	}
+	return value
+}
+
+func (ctx substitutionContext) substituteString(value string, location string) string {
+	return variablePattern.ReplaceAllStringFunc(value, func(match string) string {
+		variable := match[2 : len(match)-1]
+		kind, arg, _ := strings.Cut(variable, ":")
+		switch kind {
+		case "localEnv", "env":
+			name, defaultValue, hasDefault := strings.Cut(arg, ":")
+			if envValue, exists := os.LookupEnv(name); exists {
+				logger.Debug().Str("env", name).Str("value", envValue).Msg("set env variable")
+				return envValue
+			}
+			if !hasDefault {
+				logger.Warn().Str("file", ctx.file).Str("location", location).Str("env", name).Msg("environment variable is not set")
+			}
+			return defaultValue
+		case "containerEnv":
+			// only known once the container runs, see SubstituteContainerEnv
+			return match
+		case "localWorkspaceFolder":
+			return ctx.localWorkspaceFolder
+		case "localWorkspaceFolderBasename":
+			return filepath.Base(ctx.localWorkspaceFolder)
+		case "containerWorkspaceFolder", "containerWorkspaceFolderBasename":
+			// the workspace folder can be set by any config layer, see substituteContainerWorkspaceFolder
+			return match
+		case "devcontainerId":
+			return ctx.devcontainerID
+		default:
+			// plain names like ${HOME} are shell variables of lifecycle commands, only names with
+			// a prefix look like devcontainer variables
+			if strings.Contains(variable, ":") {
+				logger.Warn().Str("file", ctx.file).Str("location", location).Str("variable", match).Msg("unknown variable is not substituted")
+			}
+			return match
+		}
+	})
+}
+
+var containerWorkspaceFolderPattern = regexp.MustCompile(`\$\{containerWorkspaceFolder(Basename)?\}`)
+
+// substituteContainerWorkspaceFolder replaces ${containerWorkspaceFolder} and
+// ${containerWorkspaceFolderBasename} once all config layers are merged, so they follow the
+// workspaceFolder of whichever layer sets it.
+func (devc *Devcontainer) substituteContainerWorkspaceFolder() {
+	// the variables may not refer to the workspace folder itself
+	devc.Config.WorkspaceFolder = containerWorkspaceFolderPattern.ReplaceAllString(devc.Config.WorkspaceFolder, "")
+	folder := devc.GetWorkspaceFolder()
+	substitute := func(value string) string {
+		return containerWorkspaceFolderPattern.ReplaceAllStringFunc(value, func(match string) string {
+			if strings.HasSuffix(match, "Basename}") {
+				return filepath.Base(folder)
+			}
+			return folder
+		})
+	}
+	// the Dockerfile is not part of the devcontainer.json
+	dockerfile := devc.Config.DockerFileContent
+	substituteStrings(reflect.ValueOf(&devc.Config), substitute)
+	devc.Config.DockerFileContent = dockerfile
+}
+
+// substituteStrings applies fn to every string reachable from value.
+func substituteStrings(value reflect.Value, fn func(string) string) {
+	switch value.Kind() {
+	case reflect.Pointer:
+		if !value.IsNil() {
+			substituteStrings(value.Elem(), fn)
+		}
+	case reflect.String:
+		if value.CanSet() {
+			value.SetString(fn(value.String()))
+		}
+	case reflect.Struct:
+		for i := 0; i < value.NumField(); i++ {
+			if value.Type().Field(i).IsExported() {
+				substituteStrings(value.Field(i), fn)
+			}
+		}
+	case reflect.Slice:
+		for i := 0; i < value.Len(); i++ {
+			substituteStrings(value.Index(i), fn)
+		}
+	case reflect.Map:
+		// map values are not addressable, so they are substituted in a copy
+		for _, key := range value.MapKeys() {
+			elem := reflect.New(value.Type().Elem()).Elem()
+			elem.Set(value.MapIndex(key))
+			substituteStrings(elem, fn)
+			value.SetMapIndex(key, elem)
+		}
+	}
+}
+
+var containerEnvPattern = regexp.MustCompile(`\$\{containerEnv:([^}:]+)(?::([^}]*))?\}`)
+
+// SubstituteContainerEnv replaces ${containerEnv:VAR} and ${containerEnv:VAR:default}
+// with the environment of the running container.
+func SubstituteContainerEnv(value string, env map[string]string) string {
+	return containerEnvPattern.ReplaceAllStringFunc(value, func(match string) string {
+		groups := containerEnvPattern.FindStringSubmatch(match)
+		if envValue, exists := env[groups[1]]; exists {
+			return envValue
+		}
+		return groups[2]
+	})
+}
+
+// WithContainerEnv returns a copy of the devcontainer with ${containerEnv:...} substituted
+// in all values that are used inside the running container.
+func (devc Devcontainer) WithContainerEnv(env map[string]string) Devcontainer {
+	substitute := func(value string) string {
+		return SubstituteContainerEnv(value, env)
+	}
+	substituteAll := func(commands []LifecycleCommand) []LifecycleCommand {
+		result := make([]LifecycleCommand, 0, len(commands))
+		for _, command := range commands {
+			result = append(result, command.substitute(substitute))
+		}
+		return result
+	}
+	devc.Config.OnCreateCommands = substituteAll(devc.Config.OnCreateCommands)
+	devc.Config.UpdateContentCommands = substituteAll(devc.Config.UpdateContentCommands)
+	devc.Config.PostCreateCommands = substituteAll(devc.Config.PostCreateCommands)
+	devc.Config.PostStartCommands = substituteAll(devc.Config.PostStartCommands)
+	devc.Config.PostAttachCommands = substituteAll(devc.Config.PostAttachCommands)
+	devc.Config.WorkspaceFolder = substitute(devc.Config.WorkspaceFolder)
+	remoteEnv := map[string]string{}
+	for key, value := range devc.Config.RemoteEnv {
+		remoteEnv[key] = substitute(value)
+	}
+	devc.Config.RemoteEnv = remoteEnv
+	return devc
+}
@@ -0,0 +1,115 @@
+package devcontainerspec
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func TestSubstituteString(t *testing.T) {
+	t.Setenv("DEVCLI_TEST_USER", "dev")
+	t.Setenv("DEVCLI_TEST_UNSET", "")
+	os.Unsetenv("DEVCLI_TEST_UNSET")
+	tests := []struct {
+		name  string
+		value string
+		want  string
+		warn  bool
+	}{
+		{name: "localEnv", value: "${localEnv:DEVCLI_TEST_USER}", want: "dev"},
+		{name: "env alias", value: "home of ${env:DEVCLI_TEST_USER}", want: "home of dev"},
+		{name: "localEnv set with default", value: "${localEnv:DEVCLI_TEST_USER:root}", want: "dev"},
+		{name: "localEnv default", value: "${localEnv:DEVCLI_TEST_UNSET:root}", want: "root"},
+		{name: "default containing a colon", value: "${localEnv:DEVCLI_TEST_UNSET:localhost:8080}", want: "localhost:8080"},
+		{name: "unset without default", value: "[${localEnv:DEVCLI_TEST_UNSET}]", want: "[]", warn: true},
+		{name: "containerEnv is deferred", value: "${containerEnv:PATH}:/opt/bin", want: "${containerEnv:PATH}:/opt/bin"},
+		{name: "containerWorkspaceFolder is deferred", value: "${containerWorkspaceFolder}", want: "${containerWorkspaceFolder}"},
+		{name: "localWorkspaceFolder", value: "${localWorkspaceFolder}/src", want: "/home/dev/repo/src"},
+		{name: "localWorkspaceFolderBasename", value: "${localWorkspaceFolderBasename}", want: "repo"},
+		{name: "shell variable", value: "export PATH=${HOME}/bin:${PATH}", want: "export PATH=${HOME}/bin:${PATH}"},
+		{name: "unknown prefix", value: "${secret:token}", want: "${secret:token}", warn: true},
+	}
+	var logs bytes.Buffer
+	defaultLogger := logger
+	logger = zerolog.New(&logs)
+	defer func() { logger = defaultLogger }()
+	ctx := newSubstitutionContext("devcontainer.json", "/home/dev/repo")
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			logs.Reset()
+			if got := ctx.substituteString(test.value, "$.postCreateCommand"); got != test.want {
+				t.Errorf("expected %q, got %q", test.want, got)
+			}
+			if warned := bytes.Contains(logs.Bytes(), []byte(`"level":"warn"`)); warned != test.warn {
+				t.Errorf("expected warning %v, got log %q", test.warn, logs.String())
+			}
+		})
+	}
+}
+
+func TestContainerWorkspaceFolderFromMergedConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		project string
+		local   string
+		folder  string
+	}{
+		{
+			name:    "default",
+			project: `{"image": "alpine", "postCreateCommand": "cd ${containerWorkspaceFolder}"}`,
+			folder:  "/workspaces/repo",
+		},
+		{
+			name:    "workspaceFolder of the local config",
+			project: `{"image": "alpine", "postCreateCommand": "cd ${containerWorkspaceFolder}"}`,
+			local:   `{"workspaceFolder": "/src"}`,
+			folder:  "/src",
+		},
+		{
+			name:    "compose default",
+			project: `{"dockerComposeFile": "compose.yml", "service": "app", "postCreateCommand": "cd ${containerWorkspaceFolder}"}`,
+			folder:  "/",
+		},
+		{
+			name:    "workspaceFolder refers to itself",
+			project: `{"image": "alpine", "workspaceFolder": "${containerWorkspaceFolder}/src", "postCreateCommand": "cd ${containerWorkspaceFolder}"}`,
+			folder:  "/src",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+			workspace := filepath.Join(t.TempDir(), "repo")
+			configFile := filepath.Join(workspace, ".devcontainer", "devcontainer.json")
+			if err := os.MkdirAll(filepath.Dir(configFile), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(configFile, []byte(test.project), 0644); err != nil {
+				t.Fatal(err)
+			}
+			compose := "services:\n  app:\n    image: alpine\n"
+			if err := os.WriteFile(filepath.Join(filepath.Dir(configFile), "compose.yml"), []byte(compose), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if test.local != "" {
+				if err := os.WriteFile(localConfigFile(configFile), []byte(test.local), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			devc, err := ParseDevcontainer(workspace, WorkspaceConfig{Path: configFile})
+			if err != nil {
+				t.Fatal(err)
+			}
+			commands := devc.Config.PostCreateCommands
+			if len(commands) != 1 || commands[0].Shell != "cd "+test.folder {
+				t.Errorf("expected cd %s, got %+v", test.folder, commands)
+			}
+			if devc.GetWorkspaceFolder() != test.folder {
+				t.Errorf("expected workspace folder %s, got %s", test.folder, devc.GetWorkspaceFolder())
+			}
+		})
+	}
+}
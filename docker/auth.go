@@ -0,0 +1,140 @@
+package docker
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+const dockerHubAuthKey = "https://index.docker.io/v1/"
+
+type authConfig struct {
+	Username      string `json:"username,omitempty"`
+	Password      string `json:"password,omitempty"`
+	IdentityToken string `json:"identitytoken,omitempty"`
+	ServerAddress string `json:"serveraddress,omitempty"`
+}
+
+// dockerConfigFile is the part of ~/.docker/config.json needed to authenticate against registries.
+type dockerConfigFile struct {
+	Auths map[string]struct {
+		Auth          string `json:"auth"`
+		IdentityToken string `json:"identitytoken"`
+	} `json:"auths"`
+	CredsStore  string            `json:"credsStore"`
+	CredHelpers map[string]string `json:"credHelpers"`
+}
+
+func loadDockerConfig() dockerConfigFile {
+	var config dockerConfigFile
+	dir := os.Getenv("DOCKER_CONFIG")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return config
+		}
+		dir = filepath.Join(home, ".docker")
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "config.json"))
+	if err != nil {
+		return config
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		logger.Warn().Err(err).Msg("could not parse docker config file")
+	}
+	return config
+}
+
+// registryHost returns the key used in the docker config file for the registry of an image reference.
+func registryHost(image string) string {
+	first, _, found := strings.Cut(image, "/")
+	if found && (strings.ContainsAny(first, ".:") || first == "localhost") {
+		return first
+	}
+	return dockerHubAuthKey
+}
+
+// lookupAuth returns the credentials for a registry, either from a credential helper
+// or from the "auths" section of the docker config file.
+func (config dockerConfigFile) lookupAuth(registry string) (authConfig, bool) {
+	helper := config.CredHelpers[registry]
+	if helper == "" {
+		helper = config.CredsStore
+	}
+	if helper != "" {
+		if auth, ok := credentialHelperAuth(helper, registry); ok {
+			return auth, true
+		}
+	}
+	entry, ok := config.Auths[registry]
+	if !ok {
+		return authConfig{}, false
+	}
+	auth := authConfig{ServerAddress: registry, IdentityToken: entry.IdentityToken}
+	if entry.Auth != "" {
+		decoded, err := base64.StdEncoding.DecodeString(entry.Auth)
+		if err != nil {
+			return authConfig{}, false
+		}
+		auth.Username, auth.Password, _ = strings.Cut(string(decoded), ":")
+	}
+	return auth, true
+}
+
+func credentialHelperAuth(helper string, registry string) (authConfig, bool) {
+	cmd := exec.Command("docker-credential-"+helper, "get")
+	cmd.Stdin = strings.NewReader(registry)
+	output, err := cmd.Output()
+	if err != nil {
+		logger.Debug().Err(err).Str("helper", helper).Str("registry", registry).Msg("credential helper returned no credentials")
+		return authConfig{}, false
+	}
+	var creds struct {
+		Username string
+		Secret   string
+	}
+	if err := json.Unmarshal(output, &creds); err != nil {
+		return authConfig{}, false
+	}
+	auth := authConfig{ServerAddress: registry, Username: creds.Username, Password: creds.Secret}
+	if creds.Username == "<token>" {
+		auth = authConfig{ServerAddress: registry, IdentityToken: creds.Secret}
+	}
+	return auth, true
+}
+
+// registryAuthHeader encodes the credentials for the X-Registry-Auth header of a pull.
+func registryAuthHeader(image string) string {
+	auth, ok := loadDockerConfig().lookupAuth(registryHost(image))
+	if !ok {
+		return ""
+	}
+	return encodeAuthHeader(auth)
+}
+
+// registryConfigHeader encodes all stored credentials for the X-Registry-Config header of a build.
+func registryConfigHeader() string {
+	config := loadDockerConfig()
+	auths := map[string]authConfig{}
+	for registry := range config.Auths {
+		if auth, ok := config.lookupAuth(registry); ok {
+			auths[registry] = auth
+		}
+	}
+	if len(auths) == 0 {
+		return ""
+	}
+	return encodeAuthHeader(auths)
+}
+
+func encodeAuthHeader(v any) string {
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(v); err != nil {
+		return ""
+	}
+	return base64.URLEncoding.EncodeToString(buf.Bytes())
+}
@@ -2,26 +2,34 @@ package docker
 
 import (
 	"fmt"
+	"io"
+	"net/url"
 	"os"
 	"os/exec"
-	"path/filepath"
+	"path"
 	"strings"
 
 	devcontainerspec "github.com/johndoe2991/devcli/devcontainer_spec"
 )
 
-func Build(devc devcontainerspec.Devcontainer) error {
+// BuildOptions change how the image of a devcontainer is built.
+type BuildOptions struct {
+	// NoCache builds the image without the layer cache
+	NoCache bool
+}
+
+func Build(rt Runtime, devc devcontainerspec.Devcontainer, opts BuildOptions) error {
 	// first we check if we have to build or to pull a image
 	if devc.Config.Image != "" {
 		// pull the image
 		logger.Debug().Str("image", devc.Config.Image).Msg("pulling image")
-		if err := pullImage(devc.Config.Image); err != nil {
+		if err := rt.PullImage(devc.Config.Image); err != nil {
 			return fmt.Errorf("could not pull image: %w", err)
 		}
 	} else if devc.Config.DockerFileContent != "" {
 		// the image has to be build, check if the image already exists
 		imageName := devc.GetImageName()
-		exists, err := checkImageExists(imageName)
+		exists, err := rt.ImageExists(imageName)
 		if err != nil {
 			return fmt.Errorf("error checking if image exists: %w", err)
 		}
@@ -29,49 +37,168 @@ func Build(devc devcontainerspec.Devcontainer) error {
 		if !exists {
 			// build the image
 			logger.Debug().Msg("building image")
-			if err := buildImage(devc); err != nil {
+			if err := rt.BuildImage(devc, opts); err != nil {
 				return fmt.Errorf("error while building the image: %w", err)
 			}
 		}
 	} else {
 		return fmt.Errorf("no image or dockerfile specified")
 	}
+	if updatesRemoteUserUID(rt, devc) {
+		imageName := devc.GetRemoteUserImageName()
+		exists, err := rt.ImageExists(imageName)
+		if err != nil {
+			return fmt.Errorf("error checking if image exists: %w", err)
+		}
+		if !exists {
+			logger.Debug().Str("user", devc.GetRemoteUser()).Msg("updating uid of the remote user")
+			if err := rt.BuildRemoteUserImage(devc); err != nil {
+				return fmt.Errorf("error while updating the uid of the remote user: %w", err)
+			}
+		}
+	}
 	return nil
 }
 
-func pullImage(imagepath string) error {
-	// run docker and pull the image
-	err := exec.Command("docker", "pull", imagepath).Run()
+func (rt *engineRuntime) PullImage(imagepath string) error {
+	// without a tag the api pulls all tags of the repository
+	name, tag := splitImageTag(imagepath)
+	query := url.Values{"fromImage": {name}}
+	if tag != "" {
+		query.Set("tag", tag)
+	}
+	req, err := rt.client.newRequest("POST", "/images/create", query, nil)
 	if err != nil {
 		return err
 	}
-	return nil
+	if auth := registryAuthHeader(imagepath); auth != "" {
+		req.Header.Set("X-Registry-Auth", auth)
+	}
+	resp, err := rt.client.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return readJSONMessages(resp.Body, nil)
+}
+
+// splitImageTag splits an image reference into name and tag. References with a digest are
+// returned unchanged, references without tag get the "latest" tag.
+func splitImageTag(image string) (string, string) {
+	if strings.Contains(image, "@") {
+		return image, ""
+	}
+	lastSlash := strings.LastIndex(image, "/")
+	lastColon := strings.LastIndex(image, ":")
+	if lastColon > lastSlash {
+		return image[:lastColon], image[lastColon+1:]
+	}
+	return image, "latest"
+}
+
+func (rt *engineRuntime) BuildImage(devc devcontainerspec.Devcontainer, opts BuildOptions) error {
+	context := devc.GetBuildContext()
+	logger.Debug().Str("context", context).Msg("using build context")
+	// stream the build context to the daemon while it is created
+	extraDirs := map[string]string{}
+	for _, feature := range devc.Config.Features {
+		extraDirs[path.Join(devcontainerspec.FeaturesContextDir, feature.ContextName)] = feature.Dir
+	}
+	params, err := newBuildParams(devc)
+	if err != nil {
+		return err
+	}
+	params.noCache = params.noCache || opts.NoCache
+	if err := rt.setFeaturesBaseUser(devc, &params, true); err != nil {
+		return err
+	}
+	query, err := params.query()
+	if err != nil {
+		return err
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(writeBuildContext(pw, context, devc.Config.DockerFileContent, extraDirs))
+	}()
+	defer pr.Close()
+	// the build endpoint of docker only offers the legacy builder, podman supports BuildKit
+	// syntax in its API as well
+	if syntax := devcontainerspec.BuildKitSyntax(devc.Config.DockerFileContent); syntax != "" && rt.name == RuntimeDocker {
+		logger.Info().Str("syntax", syntax).Msg("Dockerfile needs BuildKit, building with docker build")
+		return rt.buildWithCLI(params, pr)
+	}
+	return rt.build(query, pr)
 }
 
-func buildImage(devc devcontainerspec.Devcontainer) error {
-	// run docker and build the image
-	context := filepath.Join(devc.Cwd, "./.devcontainer")
-	if devc.Config.Context != "" {
-		logger.Debug().Str("context", context).Msg("using custom context")
-		context = filepath.Join(context, devc.Config.Context)
-	}
-	cmd := exec.Command("docker", "build", "-f", "-", "-t", devc.GetImageName(), context)
-	cmd.Stdout = os.Stdout
+// buildWithCLI builds with the command line client of the runtime, which uses BuildKit. The
+// build context is passed as tar archive on stdin.
+func (rt *engineRuntime) buildWithCLI(params buildParams, context io.Reader) error {
+	args := append(append([]string{"build"}, params.flags()...), "-f", params.dockerfile, "-")
+	cmd := exec.Command(rt.name, args...)
+	cmd.Stdin = context
+	cmd.Stdout = output
 	cmd.Stderr = os.Stderr
-	cmd.Stdin = strings.NewReader(devc.Config.DockerFileContent)
-	err := cmd.Run()
+	logger.Debug().Strs("args", args).Msg("running build")
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s build: %w", rt.name, err)
+	}
+	return nil
+}
+
+// setFeaturesBaseUser passes the user of the image the features are installed on to the build,
+// so the image keeps its user. A missing image is pulled first if pull is set, otherwise the
+// user defaults to root.
+func (rt *engineRuntime) setFeaturesBaseUser(devc devcontainerspec.Devcontainer, params *buildParams, pull bool) error {
+	image := devc.Config.FeaturesBaseImage
+	if image == "" {
+		return nil
+	}
+	var inspect imageInspect
+	err := rt.client.doJSON("GET", "/images/"+image+"/json", nil, nil, &inspect)
+	if isNotFound(err) && pull {
+		if err := rt.PullImage(image); err != nil {
+			return fmt.Errorf("could not pull base image: %w", err)
+		}
+		err = rt.client.doJSON("GET", "/images/"+image+"/json", nil, nil, &inspect)
+	}
+	if isNotFound(err) {
+		return nil
+	}
 	if err != nil {
 		return err
 	}
+	logger.Debug().Str("image", image).Str("user", inspect.Config.User).Msg("user of the features base image")
+	if inspect.Config.User != "" {
+		params.buildArgs[devcontainerspec.FeaturesBaseUserArg] = inspect.Config.User
+	}
 	return nil
 }
 
-func checkImageExists(hash string) (bool, error) {
-	// check if the image exists
-	cmd := exec.Command("docker", "images", "-q", hash)
-	output, err := cmd.Output()
+// build sends the tar archive context to the daemon and prints the build output.
+func (rt *engineRuntime) build(query url.Values, context io.Reader) error {
+	req, err := rt.client.newRequest("POST", "/build", query, context)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-tar")
+	if config := registryConfigHeader(); config != "" {
+		req.Header.Set("X-Registry-Config", config)
+	}
+	resp, err := rt.client.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return readJSONMessages(resp.Body, output)
+}
+
+func (rt *engineRuntime) ImageExists(hash string) (bool, error) {
+	err := rt.client.doJSON("GET", "/images/"+hash+"/json", nil, nil, nil)
+	if isNotFound(err) {
+		return false, nil
+	}
 	if err != nil {
 		return false, err
 	}
-	return len(output) > 0, nil
+	return true, nil
 }
@@ -0,0 +1,30 @@
+package docker
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBuildWithCLI(t *testing.T) {
+	dir := t.TempDir()
+	script := "#!/bin/sh\necho \"$@\" > " + filepath.Join(dir, "args") + "\ncat > " + filepath.Join(dir, "context") + "\n"
+	if err := os.WriteFile(filepath.Join(dir, "docker"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	rt := &engineRuntime{name: RuntimeDocker}
+	params := buildParams{imageName: "devcli_repo_img", dockerfile: dockerfileName, target: "dev"}
+	if err := rt.buildWithCLI(params, strings.NewReader("context tar")); err != nil {
+		t.Fatal(err)
+	}
+	args, _ := os.ReadFile(filepath.Join(dir, "args"))
+	if want := "build -t devcli_repo_img --target dev -f " + dockerfileName + " -\n"; string(args) != want {
+		t.Errorf("expected args %q, got %q", want, args)
+	}
+	context, _ := os.ReadFile(filepath.Join(dir, "context"))
+	if string(context) != "context tar" {
+		t.Errorf("build context is not passed on stdin: %q", context)
+	}
+}
@@ -0,0 +1,93 @@
+package docker
+
+import (
+	"archive/tar"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+
+	devcontainerspec "github.com/johndoe2991/devcli/devcontainer_spec"
+)
+
+// dockerfileName is the name under which the generated Dockerfile is added to the build context.
+const dockerfileName = ".devcli.Dockerfile"
+
+// writeBuildContext writes the context directory as tar archive to w, honouring the
+// .dockerignore file, and adds the Dockerfile content as dockerfileName.
+// extraDirs maps additional directories in the archive to directories on the host.
+func writeBuildContext(w io.Writer, contextDir string, dockerfile string, extraDirs map[string]string) error {
+	ignore, err := devcontainerspec.ReadDockerignore(contextDir)
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(w)
+	if err := addDirToTar(tw, contextDir, "", ignore); err != nil {
+		return err
+	}
+	for archiveDir, hostDir := range extraDirs {
+		if err := addDirToTar(tw, hostDir, archiveDir, devcontainerspec.Dockerignore{}); err != nil {
+			return err
+		}
+	}
+	header := &tar.Header{
+		Name: dockerfileName,
+		Mode: 0644,
+		Size: int64(len(dockerfile)),
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(tw, dockerfile); err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// addDirToTar adds all files below dir that are not ignored to the archive, prefixed with archiveDir.
+func addDirToTar(tw *tar.Writer, dir string, archiveDir string, ignore devcontainerspec.Dockerignore) error {
+	return filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(dir, file)
+		if err != nil {
+			return err
+		}
+		if relPath == "." {
+			return nil
+		}
+		relPath = filepath.ToSlash(relPath)
+		if ignore.Ignored(relPath) {
+			if info.IsDir() && !ignore.HasExceptions() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		link := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			link, err = os.Readlink(file)
+			if err != nil {
+				return err
+			}
+		}
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		header.Name = path.Join(archiveDir, relPath)
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+}
@@ -0,0 +1,219 @@
+package docker
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	devcontainerspec "github.com/johndoe2991/devcli/devcontainer_spec"
+)
+
+// buildParams collects the parameters of an image build before they are encoded into the
+// query of the build request.
+type buildParams struct {
+	imageName  string
+	dockerfile string
+	target     string
+	buildArgs  map[string]string
+	cacheFrom  []string
+	labels     map[string]string
+	extraHosts []string
+	network    string
+	platform   string
+	shmSize    int64
+	noCache    bool
+	pull       bool
+}
+
+// newBuildParams returns the build parameters of the devcontainer image, including the
+// "build.options" of the devcontainer.json.
+func newBuildParams(devc devcontainerspec.Devcontainer) (buildParams, error) {
+	params := buildParams{
+		imageName:  devc.GetImageName(),
+		dockerfile: dockerfileName,
+		target:     devc.Config.BuildTarget,
+		buildArgs:  map[string]string{},
+		cacheFrom:  append([]string{}, devc.Config.CacheFrom...),
+		labels:     devcliLabels(devc),
+	}
+	for key, value := range devc.Config.BuildArgs {
+		params.buildArgs[key] = value
+	}
+	if err := params.applyBuildOptions(devc.Config.BuildOptions); err != nil {
+		return buildParams{}, err
+	}
+	return params, nil
+}
+
+// query encodes the parameters like the build endpoint of the Engine API expects them.
+func (p buildParams) query() (url.Values, error) {
+	query := url.Values{
+		"t":          {p.imageName},
+		"dockerfile": {p.dockerfile},
+		"rm":         {"1"},
+	}
+	if len(p.buildArgs) > 0 {
+		buildArgs, err := json.Marshal(p.buildArgs)
+		if err != nil {
+			return nil, err
+		}
+		query.Set("buildargs", string(buildArgs))
+	}
+	if len(p.cacheFrom) > 0 {
+		cacheFrom, err := json.Marshal(p.cacheFrom)
+		if err != nil {
+			return nil, err
+		}
+		query.Set("cachefrom", string(cacheFrom))
+	}
+	if len(p.labels) > 0 {
+		labels, err := json.Marshal(p.labels)
+		if err != nil {
+			return nil, err
+		}
+		query.Set("labels", string(labels))
+	}
+	if p.target != "" {
+		query.Set("target", p.target)
+	}
+	if len(p.extraHosts) > 0 {
+		query.Set("extrahosts", strings.Join(p.extraHosts, ","))
+	}
+	if p.network != "" {
+		query.Set("networkmode", p.network)
+	}
+	if p.platform != "" {
+		query.Set("platform", p.platform)
+	}
+	if p.shmSize > 0 {
+		query.Set("shmsize", strconv.FormatInt(p.shmSize, 10))
+	}
+	if p.noCache {
+		query.Set("nocache", "1")
+	}
+	if p.pull {
+		query.Set("pull", "1")
+	}
+	return query, nil
+}
+
+// buildOptionFlags lists the supported "docker build" flags and whether they take a value.
+var buildOptionFlags = map[string]bool{
+	"--build-arg":  true,
+	"--target":     true,
+	"--cache-from": true,
+	"--label":      true,
+	"--add-host":   true,
+	"--network":    true,
+	"--platform":   true,
+	"--shm-size":   true,
+	"--no-cache":   false,
+	"--pull":       false,
+}
+
+// applyBuildOptions translates the "build.options" of the devcontainer.json, which are given
+// as "docker build" command line flags, into build parameters. Unsupported flags are an error,
+// because dropping them would silently build another image than the config describes.
+func (p *buildParams) applyBuildOptions(options []string) error {
+	for i := 0; i < len(options); i++ {
+		flag, value, hasValue := strings.Cut(options[i], "=")
+		takesValue, known := buildOptionFlags[flag]
+		if !known {
+			return fmt.Errorf("unsupported build option %s", options[i])
+		}
+		if takesValue && !hasValue {
+			if i+1 >= len(options) {
+				return fmt.Errorf("build option %s needs a value", flag)
+			}
+			i++
+			value = options[i]
+		}
+		if err := p.applyBuildOption(flag, value); err != nil {
+			return fmt.Errorf("invalid build option %s: %w", flag, err)
+		}
+	}
+	return nil
+}
+
+func (p *buildParams) applyBuildOption(flag string, value string) error {
+	switch flag {
+	case "--build-arg":
+		key, argValue, _ := strings.Cut(value, "=")
+		p.buildArgs[key] = argValue
+	case "--target":
+		p.target = value
+	case "--cache-from":
+		p.cacheFrom = append(p.cacheFrom, value)
+	case "--label":
+		key, labelValue, _ := strings.Cut(value, "=")
+		if p.labels == nil {
+			p.labels = map[string]string{}
+		}
+		p.labels[key] = labelValue
+	case "--add-host":
+		p.extraHosts = append(p.extraHosts, value)
+	case "--network":
+		p.network = value
+	case "--platform":
+		p.platform = value
+	case "--shm-size":
+		size, err := parseSize(value)
+		if err != nil {
+			return err
+		}
+		p.shmSize = size
+	case "--no-cache":
+		p.noCache = true
+	case "--pull":
+		p.pull = true
+	}
+	return nil
+}
+
+// flags returns the parameters as "docker build" command line flags, without the Dockerfile.
+func (p buildParams) flags() []string {
+	flags := []string{"-t", p.imageName}
+	for _, key := range sortedKeys(p.buildArgs) {
+		flags = append(flags, "--build-arg", key+"="+p.buildArgs[key])
+	}
+	if p.target != "" {
+		flags = append(flags, "--target", p.target)
+	}
+	for _, image := range p.cacheFrom {
+		flags = append(flags, "--cache-from", image)
+	}
+	for _, key := range sortedKeys(p.labels) {
+		flags = append(flags, "--label", key+"="+p.labels[key])
+	}
+	for _, host := range p.extraHosts {
+		flags = append(flags, "--add-host", host)
+	}
+	if p.network != "" {
+		flags = append(flags, "--network", p.network)
+	}
+	if p.platform != "" {
+		flags = append(flags, "--platform", p.platform)
+	}
+	if p.shmSize > 0 {
+		flags = append(flags, "--shm-size", strconv.FormatInt(p.shmSize, 10))
+	}
+	if p.noCache {
+		flags = append(flags, "--no-cache")
+	}
+	if p.pull {
+		flags = append(flags, "--pull")
+	}
+	return flags
+}
+
+func sortedKeys(values map[string]string) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
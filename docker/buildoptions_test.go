@@ -0,0 +1,49 @@
+package docker
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestApplyBuildOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		options []string
+		want    buildParams
+		wantErr bool
+	}{
+		{
+			name:    "flags with separate and inline values",
+			options: []string{"--build-arg", "USER=dev", "--target=dev", "--add-host", "db:10.0.0.2", "--shm-size", "1g", "--no-cache"},
+			want: buildParams{
+				buildArgs:  map[string]string{"USER": "dev"},
+				target:     "dev",
+				extraHosts: []string{"db:10.0.0.2"},
+				shmSize:    1 << 30,
+				noCache:    true,
+			},
+		},
+		{name: "missing value", options: []string{"--network"}, wantErr: true},
+		{name: "invalid value", options: []string{"--shm-size", "lots"}, wantErr: true},
+		{name: "unsupported flag", options: []string{"--ssh", "default"}, wantErr: true},
+		{name: "unsupported flag with inline value", options: []string{"--secret=id=npm"}, wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			params := buildParams{buildArgs: map[string]string{}}
+			err := params.applyBuildOptions(test.options)
+			if test.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(params, test.want) {
+				t.Errorf("expected %+v, got %+v", test.want, params)
+			}
+		})
+	}
+}
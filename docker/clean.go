@@ -1,16 +1,20 @@
 package docker
 
 import (
-	"os/exec"
+	"os"
 	"strings"
 
 	devcontainerspec "github.com/johndoe2991/devcli/devcontainer_spec"
 )
 
 // Delete a single Image.
-func CleanImage(imageName string) error {
+func CleanImage(rt Runtime, imageName string) error {
 	logger.Debug().Str("imageName", imageName).Msg("delete image")
-	exists, err := checkImageExists(imageName)
+	if imageName == "" {
+		// compose setups have no image of their own
+		return nil
+	}
+	exists, err := rt.ImageExists(imageName)
 	if err != nil {
 		return err
 	}
@@ -19,18 +23,13 @@ func CleanImage(imageName string) error {
 		// image does not exists, return
 		return nil
 	}
-	cmd := exec.Command("docker", "image", "rm", imageName)
-	err = cmd.Run()
-	if err != nil {
-		return err
-	}
-	return nil
+	return rt.RemoveImage(imageName)
 }
 
 // Delete a single Container. If the container is running, it will be stopped.
-func CleanContainer(containerName string) error {
+func CleanContainer(rt Runtime, containerName string) error {
 	logger.Debug().Str("containerName", containerName).Msg("delete container")
-	exists, err := checkContainerExists(containerName)
+	exists, err := rt.ContainerExists(containerName)
 	if err != nil {
 		return err
 	}
@@ -39,76 +38,57 @@ func CleanContainer(containerName string) error {
 		logger.Debug().Str("containerName", containerName).Msg("container does not exist")
 		return nil
 	}
-	isRunning, err := checkContainerRunning(containerName)
+	isRunning, err := rt.ContainerRunning(containerName)
 	if err != nil {
 		return err
 	}
 	if isRunning {
-		err = stopContainer(containerName)
+		err = rt.StopContainer(containerName)
 		if err != nil {
 			return err
 		}
 	}
-	cmd := exec.Command("docker", "container", "rm", containerName)
-	err = cmd.Run()
-	if err != nil {
-		return err
-	}
-	return nil
+	return rt.RemoveContainer(containerName)
 }
 
-// Delete all images corresponding to the devcontainer config.
-func CleanAllImageVersions(devc devcontainerspec.Devcontainer) error {
-	logger.Debug().Str("prefix", devc.GetDevcNamePrefix()).Msg("clean all images with prefix")
-	images, err := listImage()
-	if err != nil {
-		return err
-	}
-	logger.Debug().Strs("images", images).Msg("found images")
-	baseName := devc.GetDevcNamePrefix()
-	for _, image := range images {
-		if strings.HasPrefix(image, baseName) {
-			err := CleanImage(image)
-			if err != nil {
-				return err
-			}
-		}
-	}
-	return nil
+// Delete all images of all configs of the workspace.
+func CleanAllImageVersions(rt Runtime, devc devcontainerspec.Devcontainer) error {
+	logger.Debug().Str("workspace", devc.Cwd).Msg("clean all images of the workspace")
+	return cleanImages(rt, workspaceLabels(devc))
 }
 
-// Delete all containers corresponding to the devcontainer config.
-func CleanAllContainerVersions(devc devcontainerspec.Devcontainer) error {
-	logger.Debug().Str("prefix", devc.GetDevcNamePrefix()).Msg("clean all containers with prefix")
-	containers, err := listContainers()
-	if err != nil {
-		return err
-	}
-	logger.Debug().Strs("containers", containers).Msg("found containers")
-	baseName := devc.GetDevcNamePrefix()
-	for _, container := range containers {
-		if strings.HasPrefix(container, baseName) {
-			err := CleanContainer(container)
-			if err != nil {
-				return err
-			}
-		}
-	}
-	return nil
+// Delete all containers of all configs of the workspace.
+func CleanAllContainerVersions(rt Runtime, devc devcontainerspec.Devcontainer) error {
+	logger.Debug().Str("workspace", devc.Cwd).Msg("clean all containers of the workspace")
+	return cleanContainers(rt, workspaceLabels(devc))
+}
+
+// Delete the images built for the current config. Images given by "image" are not
+// owned by devcli and are kept.
+func CleanConfigImages(rt Runtime, devc devcontainerspec.Devcontainer) error {
+	logger.Debug().Str("workspace", devc.Cwd).Str("hash", devc.Hash).Msg("clean images of the config")
+	return cleanImages(rt, configLabels(devc))
 }
 
 // Delete all devcli images.
-func CleanAllImages() error {
+func CleanAllImages(rt Runtime) error {
 	logger.Debug().Msg("clean all images")
-	images, err := listImage()
+	return cleanImages(rt, anyLabels())
+}
+
+// Delete all devcli containers.
+func CleanAllContainers(rt Runtime) error {
+	logger.Debug().Msg("clean all containers")
+	return cleanContainers(rt, anyLabels())
+}
+
+func cleanImages(rt Runtime, labels map[string]string) error {
+	images, err := rt.ListImages(labels)
 	if err != nil {
 		return err
 	}
 	for _, image := range images {
-		if image == "" {
-			continue
-		}
-		err := CleanImage(image)
+		err := CleanImage(rt, image.Name)
 		if err != nil {
 			return err
 		}
@@ -116,21 +96,52 @@ func CleanAllImages() error {
 	return nil
 }
 
-// Delete all devcli containers.
-func CleanAllContainers() error {
-	logger.Debug().Msg("clean all containers")
-	containers, err := listContainers()
+func cleanContainers(rt Runtime, labels map[string]string) error {
+	containers, err := rt.ListContainers(labels)
 	if err != nil {
 		return err
 	}
 	for _, container := range containers {
-		if container == "" {
-			continue
-		}
-		err := CleanContainer(container)
+		err := cleanListedContainer(rt, container)
 		if err != nil {
 			return err
 		}
 	}
 	return nil
 }
+
+// cleanListedContainer deletes a container found by its labels. The container of a compose
+// devcontainer is deleted together with the other services, networks and volumes of its project,
+// using the compose files the project was started with.
+func cleanListedContainer(rt Runtime, container ContainerInfo) error {
+	if project := container.Labels[composeProjectLabel]; project != "" {
+		files := composeConfigFiles(container.Labels)
+		logger.Debug().Str("project", project).Strs("files", files).Msg("delete compose project")
+		return rt.ComposeDown(project, files)
+	}
+	return CleanContainer(rt, container.Name)
+}
+
+func (rt *engineRuntime) RemoveImage(imageName string) error {
+	return rt.client.doJSON("DELETE", "/images/"+imageName, nil, nil, nil)
+}
+
+func (rt *engineRuntime) RemoveContainer(containerName string) error {
+	return rt.client.doJSON("DELETE", "/containers/"+containerName, nil, nil, nil)
+}
+
+// composeConfigFiles returns the compose files of a container from the label compose sets.
+// If a file no longer exists, none are returned and compose resolves what it can from the labels.
+func composeConfigFiles(labels map[string]string) []string {
+	if labels[composeConfigFilesLabel] == "" {
+		return nil
+	}
+	files := strings.Split(labels[composeConfigFilesLabel], ",")
+	for _, file := range files {
+		if _, err := os.Stat(file); err != nil {
+			logger.Debug().Str("file", file).Msg("compose file of the project is missing")
+			return nil
+		}
+	}
+	return files
+}
@@ -0,0 +1,226 @@
+package docker
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+)
+
+const (
+	defaultDockerHost = "unix:///var/run/docker.sock"
+	apiVersion        = "v1.41"
+)
+
+// apiError is returned for every response of the Engine API with a status code >= 400.
+type apiError struct {
+	StatusCode int
+	Method     string
+	Path       string
+	Message    string
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("%s %s: %s (status %d)", e.Method, e.Path, e.Message, e.StatusCode)
+}
+
+// isNotFound reports whether err is an Engine API error with status 404.
+func isNotFound(err error) bool {
+	var apiErr *apiError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
+
+// client talks to the Docker Engine API over a unix socket or plain tcp.
+type client struct {
+	network string
+	address string
+	http    *http.Client
+}
+
+func newClient(host string) (*client, error) {
+	if host == "" {
+		host = defaultDockerHost
+	}
+	u, err := url.Parse(host)
+	if err != nil {
+		return nil, fmt.Errorf("invalid docker host %q: %w", host, err)
+	}
+	c := &client{}
+	switch u.Scheme {
+	case "unix":
+		c.network = "unix"
+		c.address = u.Path
+	case "tcp":
+		c.network = "tcp"
+		c.address = u.Host
+	default:
+		return nil, fmt.Errorf("unsupported docker host scheme %q", u.Scheme)
+	}
+	c.http = &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return c.dial(ctx)
+			},
+		},
+	}
+	logger.Debug().Str("network", c.network).Str("address", c.address).Msg("created docker api client")
+	return c, nil
+}
+
+func (c *client) dial(ctx context.Context) (net.Conn, error) {
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, c.network, c.address)
+}
+
+func (c *client) newRequest(method string, path string, query url.Values, body io.Reader) (*http.Request, error) {
+	u := url.URL{
+		Scheme:   "http",
+		Host:     "docker",
+		Path:     "/" + apiVersion + path,
+		RawQuery: query.Encode(),
+	}
+	return http.NewRequest(method, u.String(), body)
+}
+
+// do sends the request and converts error responses into an apiError. Errors are wrapped
+// in a RuntimeError.
+// The caller has to close the body of the returned response.
+func (c *client) do(req *http.Request) (*http.Response, error) {
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, &RuntimeError{Err: err}
+	}
+	if resp.StatusCode >= 400 {
+		defer resp.Body.Close()
+		return nil, &RuntimeError{Err: readAPIError(req, resp)}
+	}
+	return resp, nil
+}
+
+func readAPIError(req *http.Request, resp *http.Response) error {
+	apiErr := &apiError{
+		StatusCode: resp.StatusCode,
+		Method:     req.Method,
+		Path:       req.URL.Path,
+	}
+	body, _ := io.ReadAll(resp.Body)
+	var msg struct {
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(body, &msg); err == nil && msg.Message != "" {
+		apiErr.Message = msg.Message
+	} else {
+		apiErr.Message = string(bytes.TrimSpace(body))
+	}
+	return apiErr
+}
+
+// doJSON sends in as JSON body (if not nil) and decodes the response into out (if not nil).
+func (c *client) doJSON(method string, path string, query url.Values, in any, out any) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := c.newRequest(method, path, query, body)
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		_, err = io.Copy(io.Discard, resp.Body)
+		return err
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// hijack sends the request on a dedicated connection and takes over the raw stream
+// after the daemon switched protocols. Used for attaching to exec sessions.
+func (c *client) hijack(method string, path string, in any) (net.Conn, *bufio.Reader, error) {
+	data, err := json.Marshal(in)
+	if err != nil {
+		return nil, nil, err
+	}
+	req, err := c.newRequest(method, path, nil, bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "tcp")
+
+	conn, err := c.dial(context.Background())
+	if err != nil {
+		return nil, nil, &RuntimeError{Err: err}
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	if resp.StatusCode >= 400 {
+		defer conn.Close()
+		return nil, nil, &RuntimeError{Err: readAPIError(req, resp)}
+	}
+	return conn, br, nil
+}
+
+// jsonMessage is a single line of the progress stream returned by pull and build.
+type jsonMessage struct {
+	Stream      string `json:"stream"`
+	Status      string `json:"status"`
+	Progress    string `json:"progress"`
+	ID          string `json:"id"`
+	Error       string `json:"error"`
+	ErrorDetail struct {
+		Message string `json:"message"`
+	} `json:"errorDetail"`
+}
+
+// readJSONMessages consumes a progress stream. Build output is written to out,
+// pull status lines are only logged. The first error in the stream is returned.
+func readJSONMessages(r io.Reader, out io.Writer) error {
+	decoder := json.NewDecoder(r)
+	for {
+		var msg jsonMessage
+		if err := decoder.Decode(&msg); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if msg.Error != "" {
+			if msg.ErrorDetail.Message != "" {
+				return errors.New(msg.ErrorDetail.Message)
+			}
+			return errors.New(msg.Error)
+		}
+		if msg.Stream != "" && out != nil {
+			io.WriteString(out, msg.Stream)
+		}
+		if msg.Status != "" {
+			logger.Debug().Str("id", msg.ID).Str("progress", msg.Progress).Msg(msg.Status)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package docker
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newTestClient starts handler as fake Engine API on a temporary unix socket and returns a
+// client connected to it.
+func newTestClient(t *testing.T, handler http.Handler) *client {
+	t.Helper()
+	socket := filepath.Join(t.TempDir(), "docker.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewUnstartedServer(handler)
+	server.Listener = listener
+	server.Start()
+	t.Cleanup(server.Close)
+	c, err := newClient("unix://" + socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestDoJSON(t *testing.T) {
+	c := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/"+apiVersion+"/containers/devc/json" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		if r.URL.Query().Get("size") != "true" {
+			t.Errorf("query is not passed: %s", r.URL.RawQuery)
+		}
+		io.WriteString(w, `{"Id": "abc", "State": {"Status": "running", "Running": true}}`)
+	}))
+	var inspect containerInspect
+	if err := c.doJSON("GET", "/containers/devc/json", map[string][]string{"size": {"true"}}, nil, &inspect); err != nil {
+		t.Fatal(err)
+	}
+	if inspect.ID != "abc" || !inspect.State.Running {
+		t.Errorf("unexpected response %+v", inspect)
+	}
+}
+
+func TestDoJSONNotFound(t *testing.T) {
+	c := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		io.WriteString(w, `{"message": "No such container: devc"}`)
+	}))
+	err := c.doJSON("GET", "/containers/devc/json", nil, nil, &containerInspect{})
+	if !isNotFound(err) {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+	var runtimeErr *RuntimeError
+	if !errors.As(err, &runtimeErr) {
+		t.Errorf("expected a RuntimeError, got %T", err)
+	}
+	var apiErr *apiError
+	if !errors.As(err, &apiErr) || apiErr.Message != "No such container: devc" || apiErr.Method != "GET" {
+		t.Errorf("unexpected api error %+v", apiErr)
+	}
+}
+
+func TestReadAPIErrorPlainText(t *testing.T) {
+	c := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, "daemon exploded\n")
+	}))
+	err := c.doJSON("POST", "/containers/create", nil, containerCreateConfig{Image: "alpine"}, nil)
+	var apiErr *apiError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("expected an api error, got %v", err)
+	}
+	if apiErr.StatusCode != http.StatusInternalServerError || apiErr.Message != "daemon exploded" {
+		t.Errorf("unexpected api error %+v", apiErr)
+	}
+	if isNotFound(err) {
+		t.Error("status 500 is reported as not found")
+	}
+}
+
+func TestReadJSONMessages(t *testing.T) {
+	tests := []struct {
+		name    string
+		stream  string
+		out     string
+		wantErr string
+	}{
+		{
+			name:   "build output",
+			stream: `{"stream": "Step 1/2\n"}{"status": "Downloading", "id": "abc"}{"stream": "done\n"}`,
+			out:    "Step 1/2\ndone\n",
+		},
+		{
+			name:    "error detail",
+			stream:  `{"stream": "Step 1/2\n"}{"error": "short", "errorDetail": {"message": "RUN failed"}}{"stream": "never\n"}`,
+			out:     "Step 1/2\n",
+			wantErr: "RUN failed",
+		},
+		{
+			name:    "error without detail",
+			stream:  `{"error": "pull access denied"}`,
+			wantErr: "pull access denied",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var out strings.Builder
+			err := readJSONMessages(strings.NewReader(test.stream), &out)
+			if test.wantErr == "" && err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if test.wantErr != "" && (err == nil || err.Error() != test.wantErr) {
+				t.Fatalf("expected error %q, got %v", test.wantErr, err)
+			}
+			if out.String() != test.out {
+				t.Errorf("expected output %q, got %q", test.out, out.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,192 @@
+package docker
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	devcontainerspec "github.com/johndoe2991/devcli/devcontainer_spec"
+)
+
+// labels set by compose on the containers of a project
+const (
+	composeProjectLabel     = "com.docker.compose.project"
+	composeConfigFilesLabel = "com.docker.compose.project.config_files"
+)
+
+// composeCommandArgs returns the arguments for the compose plugin of the runtime, without the
+// runtime command itself.
+func (rt *engineRuntime) composeCommandArgs(project string, files []string, args ...string) []string {
+	cmdargs := append([]string{}, rt.composeArgs[1:]...)
+	for _, file := range files {
+		cmdargs = append(cmdargs, "-f", file)
+	}
+	cmdargs = append(cmdargs, "-p", project)
+	return append(cmdargs, args...)
+}
+
+// composeCommand runs the compose plugin of the runtime, e.g. "docker compose", for the project.
+func (rt *engineRuntime) composeCommand(project string, files []string, args ...string) error {
+	cmdargs := rt.composeCommandArgs(project, files, args...)
+	cmd := exec.Command(rt.composeArgs[0], cmdargs...)
+	cmd.Stdout = output
+	cmd.Stderr = os.Stderr
+	logger.Debug().Str("project", project).Strs("args", cmdargs).Msg("running compose")
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s %v: %w", rt.composeArgs[0], cmdargs, err)
+	}
+	return nil
+}
+
+func (rt *engineRuntime) ComposeUp(project string, files []string, services []string) error {
+	return rt.composeCommand(project, files, append([]string{"up", "-d"}, services...)...)
+}
+
+func (rt *engineRuntime) ComposeBuild(project string, files []string, noCache bool) error {
+	args := []string{"build"}
+	if noCache {
+		args = append(args, "--no-cache")
+	}
+	return rt.composeCommand(project, files, args...)
+}
+
+func (rt *engineRuntime) ComposeStop(project string, files []string) error {
+	return rt.composeCommand(project, files, "stop")
+}
+
+func (rt *engineRuntime) ComposeDown(project string, files []string) error {
+	return rt.composeCommand(project, files, "down", "--remove-orphans")
+}
+
+// ComposeContainer returns the container of a compose service. The name is empty if the
+// service has no container yet.
+func (rt *engineRuntime) ComposeContainer(project string, service string) (string, bool, error) {
+	query, err := filterQuery(map[string][]string{
+		"label": {
+			composeProjectLabel + "=" + project,
+			"com.docker.compose.service=" + service,
+		},
+	})
+	if err != nil {
+		return "", false, err
+	}
+	query.Set("all", "true")
+	var containers []containerSummary
+	if err := rt.client.doJSON("GET", "/containers/json", query, nil, &containers); err != nil {
+		return "", false, err
+	}
+	if len(containers) == 0 {
+		return "", false, nil
+	}
+	return containers[0].ID, containers[0].State == "running", nil
+}
+
+// startComposeService brings up the compose project and returns the container of the devcontainer
+// service and whether it was created or started by this call.
+func startComposeService(rt Runtime, devc devcontainerspec.Devcontainer) (string, bool, bool, error) {
+	project := devc.GetComposeProject()
+	service := devc.Config.Service
+	containerName, running, err := rt.ComposeContainer(project, service)
+	if err != nil {
+		return "", false, false, err
+	}
+	// a container of an older version of the config is recreated by compose up
+	outdated := false
+	if containerName != "" {
+		current, err := rt.ListContainers(map[string]string{composeProjectLabel: project, LabelHash: devc.Hash})
+		if err != nil {
+			return "", false, false, err
+		}
+		outdated = len(current) == 0
+	}
+	logger.Debug().Str("project", project).Str("service", service).Str("container", containerName).Bool("running", running).Bool("outdated", outdated).Msg("checking compose service")
+	if running && !outdated {
+		return containerName, false, false, nil
+	}
+	created := containerName == "" || outdated
+	if created && len(devc.Config.ContainerEnv) > 0 {
+		logger.Warn().Str("service", service).Msg("containerEnv is ignored for docker compose devcontainers, set the environment in the compose file")
+	}
+	services := []string{}
+	if len(devc.Config.RunServices) > 0 {
+		services = append(services, devc.Config.RunServices...)
+		found := false
+		for _, runService := range services {
+			found = found || runService == service
+		}
+		if !found {
+			services = append(services, service)
+		}
+	}
+	files, err := composeFiles(rt, devc)
+	if err != nil {
+		return "", false, false, err
+	}
+	if err := rt.ComposeUp(project, files, services); err != nil {
+		return "", false, false, err
+	}
+	containerName, _, err = rt.ComposeContainer(project, service)
+	if err != nil {
+		return "", false, false, err
+	}
+	if containerName == "" {
+		return "", false, false, fmt.Errorf("compose project %s has no container for service %s", project, service)
+	}
+	return containerName, created, true, nil
+}
+
+// CleanCompose removes all containers and networks of the compose project.
+func CleanCompose(rt Runtime, devc devcontainerspec.Devcontainer) error {
+	logger.Debug().Str("project", devc.GetComposeProject()).Msg("delete compose project")
+	return rt.ComposeDown(devc.GetComposeProject(), devc.Config.ComposeFiles)
+}
+
+// composeFiles returns the compose files of the devcontainer followed by a generated override
+// file, which adds the devcli labels to the devcontainer service. The labels let list, clean
+// and the stale check find the container like the ones of other devcontainers.
+func composeFiles(rt Runtime, devc devcontainerspec.Devcontainer) ([]string, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return nil, err
+	}
+	labels := devcliLabels(devc)
+	// a changing label would make compose recreate the container on every start
+	delete(labels, LabelCreated)
+	keys := []string{}
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var sb strings.Builder
+	sb.WriteString("services:\n")
+	fmt.Fprintf(&sb, "  %s:\n    labels:\n", composeQuote(devc.Config.Service))
+	for _, key := range keys {
+		fmt.Fprintf(&sb, "      %s: %s\n", composeQuote(key), composeQuote(labels[key]))
+	}
+	file := filepath.Join(cacheDir, "devcli", "compose", devc.GetComposeProject()+".yml")
+	if writer, ok := rt.(hostFileWriter); ok {
+		if err := writer.WriteHostFile(file, []byte(sb.String())); err != nil {
+			return nil, err
+		}
+		return append(append([]string{}, devc.Config.ComposeFiles...), file), nil
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(file, []byte(sb.String()), 0644); err != nil {
+		return nil, err
+	}
+	logger.Debug().Str("file", file).Msg("generated compose override")
+	return append(append([]string{}, devc.Config.ComposeFiles...), file), nil
+}
+
+// composeQuote quotes a value for a compose file. JSON strings are valid YAML, "$" is escaped
+// because compose interpolates variables.
+func composeQuote(value string) string {
+	data, _ := json.Marshal(strings.ReplaceAll(value, "$", "$$"))
+	return string(data)
+}
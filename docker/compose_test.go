@@ -0,0 +1,98 @@
+package docker
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	devcontainerspec "github.com/johndoe2991/devcli/devcontainer_spec"
+)
+
+func TestComposeFiles(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	devc := devcontainerspec.Devcontainer{
+		Cwd:        "/home/dev/$project",
+		ConfigName: "api",
+		Hash:       "0123456789abcdef",
+		Config: devcontainerspec.DevcontainerConfig{
+			ComposeFiles: []string{"/home/dev/project/.devcontainer/compose.yml"},
+			Service:      "app",
+		},
+	}
+	files, err := composeFiles(nil, devc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || files[0] != devc.Config.ComposeFiles[0] {
+		t.Fatalf("override is not appended to the compose files: %v", files)
+	}
+	if filepath.Dir(files[1]) != filepath.Join(os.Getenv("XDG_CACHE_HOME"), "devcli", "compose") {
+		t.Errorf("unexpected override file %s", files[1])
+	}
+	content, err := os.ReadFile(files[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `services:
+  "app":
+    labels:
+      "devcli.config": "api"
+      "devcli.config-hash": "0123456789abcdef"
+      "devcli.version": "dev"
+      "devcli.workspace": "/home/dev/$$project"
+`
+	if string(content) != expected {
+		t.Errorf("unexpected override:\n%s", content)
+	}
+}
+
+func TestComposeConfigFiles(t *testing.T) {
+	dir := t.TempDir()
+	compose := filepath.Join(dir, "compose.yml")
+	override := filepath.Join(dir, "override.yml")
+	for _, file := range []string{compose, override} {
+		if err := os.WriteFile(file, []byte("services: {}\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		name  string
+		label string
+		want  []string
+	}{
+		{name: "no label"},
+		{name: "all files exist", label: compose + "," + override, want: []string{compose, override}},
+		{name: "missing file", label: compose + "," + filepath.Join(dir, "gone.yml")},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			labels := map[string]string{composeConfigFilesLabel: test.label}
+			if got := composeConfigFiles(labels); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("expected %v, got %v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestComposeFilesDryRun(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	var out strings.Builder
+	rt := &dryRunRuntime{engineRuntime: &engineRuntime{name: RuntimeDocker}, out: &out}
+	devc := devcontainerspec.Devcontainer{
+		Cwd:    "/home/dev/project",
+		Hash:   "0123456789abcdef",
+		Config: devcontainerspec.DevcontainerConfig{Service: "app"},
+	}
+	files, err := composeFiles(rt, devc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(os.Getenv("XDG_CACHE_HOME"), "devcli")); !os.IsNotExist(err) {
+		t.Errorf("dry run wrote to the cache directory: %v", err)
+	}
+	if len(files) != 1 || !strings.Contains(out.String(), "cat > "+files[0]+" <<'DEVCLI_FILE'\nservices:\n") {
+		t.Errorf("override is not printed for %v:\n%s", files, out.String())
+	}
+}
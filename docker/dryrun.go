@@ -0,0 +1,304 @@
+package docker
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	devcontainerspec "github.com/johndoe2991/devcli/devcontainer_spec"
+)
+
+// dryRunRuntime prints the commands that change containers or images as copy-pasteable shell
+// lines instead of executing them. Read-only queries still go to the daemon, so the printed
+// commands are the ones a real run would execute. Containers and images created or removed
+// by printed commands are remembered to answer later queries consistently.
+type dryRunRuntime struct {
+	*engineRuntime
+	out io.Writer
+	mu  sync.Mutex
+	// containers and images map names to whether they exist after the printed commands
+	containers map[string]bool
+	images     map[string]bool
+	// composeProjects maps compose projects to whether they are up after the printed commands
+	composeProjects map[string]bool
+}
+
+// hostCommandRunner is implemented by runtimes that replace the execution of lifecycle commands on the host.
+type hostCommandRunner interface {
+	RunHostCommand(dir string, args []string, w io.Writer) error
+}
+
+// hostFileWriter is implemented by runtimes that replace writing generated files on the host.
+type hostFileWriter interface {
+	WriteHostFile(name string, data []byte) error
+}
+
+// isDryRun reports whether the runtime only prints commands, the host must stay unchanged then.
+func isDryRun(rt Runtime) bool {
+	_, ok := rt.(*dryRunRuntime)
+	return ok
+}
+
+// NewDryRunRuntime returns the runtime with the given name in dry run mode.
+func NewDryRunRuntime(name string) (Runtime, error) {
+	rt, err := NewRuntime(name)
+	if err != nil {
+		return nil, err
+	}
+	return &dryRunRuntime{
+		engineRuntime:   rt.(*engineRuntime),
+		out:             os.Stdout,
+		containers:      map[string]bool{},
+		images:          map[string]bool{},
+		composeProjects: map[string]bool{},
+	}, nil
+}
+
+// print writes a command line, commands of parallel lifecycle commands do not interleave.
+func (rt *dryRunRuntime) print(args ...string) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	fmt.Fprintln(rt.out, shellJoin(args))
+}
+
+func (rt *dryRunRuntime) PullImage(image string) error {
+	rt.print(rt.name, "pull", image)
+	rt.images[image] = true
+	return nil
+}
+
+func (rt *dryRunRuntime) BuildImage(devc devcontainerspec.Devcontainer, opts BuildOptions) error {
+	params, err := newBuildParams(devc)
+	if err != nil {
+		return err
+	}
+	params.noCache = params.noCache || opts.NoCache
+	if err := rt.setFeaturesBaseUser(devc, &params, false); err != nil {
+		return err
+	}
+	for _, feature := range devc.Config.Features {
+		fmt.Fprintf(rt.out, "# the build context contains %s as %s\n", feature.Dir, path.Join(devcontainerspec.FeaturesContextDir, feature.ContextName))
+	}
+	args := append([]string{rt.name, "build"}, params.flags()...)
+	rt.printWithDockerfile(append(args, "-f", "-", devc.GetBuildContext()), devc.Config.DockerFileContent)
+	rt.images[params.imageName] = true
+	return nil
+}
+
+func (rt *dryRunRuntime) BuildRemoteUserImage(devc devcontainerspec.Devcontainer) error {
+	params, err := rt.remoteUserBuildParams(devc)
+	if err != nil && !isNotFound(err) {
+		return err
+	}
+	if err != nil {
+		// the base image is only built by a printed command, so its user is unknown
+		params = newRemoteUserBuildParams(devc, "")
+	}
+	args := append([]string{rt.name, "build"}, params.flags()...)
+	rt.printWithDockerfile(append(args, "-"), updateUIDDockerfile)
+	rt.images[params.imageName] = true
+	return nil
+}
+
+// printWithDockerfile prints a build command that reads the Dockerfile from a heredoc.
+func (rt *dryRunRuntime) printWithDockerfile(args []string, dockerfile string) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	fmt.Fprintf(rt.out, "%s <<'DEVCLI_DOCKERFILE'\n%s\nDEVCLI_DOCKERFILE\n", shellJoin(args), strings.TrimRight(dockerfile, "\n"))
+}
+
+func (rt *dryRunRuntime) ImageExists(imageName string) (bool, error) {
+	if exists, ok := rt.images[imageName]; ok {
+		return exists, nil
+	}
+	return rt.engineRuntime.ImageExists(imageName)
+}
+
+func (rt *dryRunRuntime) RemoveImage(imageName string) error {
+	rt.print(rt.name, "rmi", imageName)
+	rt.images[imageName] = false
+	return nil
+}
+
+func (rt *dryRunRuntime) CreateAndStartContainer(devc devcontainerspec.Devcontainer) error {
+	args := []string{rt.name, "run", "-d", "--name", devc.GetContainerName()}
+	if devc.Config.ContainerUser != "" {
+		args = append(args, "--user", devc.Config.ContainerUser)
+	}
+	if rt.usernsMode != "" {
+		args = append(args, "--userns", rt.usernsMode)
+	}
+	for _, env := range devcontainerspec.EnvList(devc.Config.ContainerEnv) {
+		args = append(args, "-e", env)
+	}
+	labels := devcliLabels(devc)
+	for _, key := range sortedKeys(labels) {
+		args = append(args, "--label", key+"="+labels[key])
+	}
+	if devc.Config.WorkspaceMount != "" {
+		args = append(args, "--mount", devc.Config.WorkspaceMount)
+	} else {
+		args = append(args, "-v", devc.Cwd+":/workspaces/"+filepath.Base(devc.Cwd))
+	}
+	for _, mount := range devc.Config.Mounts {
+		args = append(args, "--mount", mount)
+	}
+	args = append(args, devc.Config.RunArgs...)
+	args = append(args, containerImageName(rt, devc), "/bin/bash", "-c", "while true; do sleep 5; done;")
+	rt.print(args...)
+	rt.containers[devc.GetContainerName()] = true
+	return nil
+}
+
+func (rt *dryRunRuntime) ContainerExists(containerName string) (bool, error) {
+	if exists, ok := rt.containers[containerName]; ok {
+		return exists, nil
+	}
+	return rt.engineRuntime.ContainerExists(containerName)
+}
+
+func (rt *dryRunRuntime) ContainerRunning(containerName string) (bool, error) {
+	if exists, ok := rt.containers[containerName]; ok {
+		return exists, nil
+	}
+	return rt.engineRuntime.ContainerRunning(containerName)
+}
+
+func (rt *dryRunRuntime) ContainerState(containerName string) (ContainerState, error) {
+	if exists, ok := rt.containers[containerName]; ok && exists {
+		return ContainerState{Status: "running", Running: true}, nil
+	}
+	return rt.engineRuntime.ContainerState(containerName)
+}
+
+func (rt *dryRunRuntime) ContainerEnv(containerName string) (map[string]string, error) {
+	if _, ok := rt.containers[containerName]; ok {
+		// the environment of a container that is only created by a printed command is unknown
+		return map[string]string{}, nil
+	}
+	return rt.engineRuntime.ContainerEnv(containerName)
+}
+
+func (rt *dryRunRuntime) StartContainer(containerName string) error {
+	rt.print(rt.name, "start", containerName)
+	rt.containers[containerName] = true
+	return nil
+}
+
+func (rt *dryRunRuntime) StopContainer(containerName string) error {
+	rt.print(rt.name, "stop", containerName)
+	return nil
+}
+
+func (rt *dryRunRuntime) RemoveContainer(containerName string) error {
+	rt.print(rt.name, "rm", containerName)
+	rt.containers[containerName] = false
+	return nil
+}
+
+func (rt *dryRunRuntime) ProbeExec(containerName string) error {
+	return nil
+}
+
+func (rt *dryRunRuntime) ExecCommand(containerName string, opts ExecOptions) error {
+	args := []string{rt.name, "exec"}
+	if opts.AttachStdin {
+		args = append(args, "-i")
+	}
+	if opts.Tty {
+		args = append(args, "-t")
+	}
+	execUser, err := rt.execUserFor(opts)
+	if err != nil {
+		return err
+	}
+	if execUser != "" {
+		args = append(args, "-u", execUser)
+	}
+	if opts.WorkingDir != "" {
+		args = append(args, "-w", opts.WorkingDir)
+	}
+	for _, env := range devcontainerspec.EnvList(opts.Env) {
+		args = append(args, "-e", env)
+	}
+	rt.print(append(append(args, containerName), opts.Cmd...)...)
+	return nil
+}
+
+func (rt *dryRunRuntime) RunHostCommand(dir string, args []string, w io.Writer) error {
+	rt.print(append([]string{"cd", dir, "&&"}, args...)...)
+	return nil
+}
+
+// WriteHostFile prints the commands that write the file instead of writing it.
+func (rt *dryRunRuntime) WriteHostFile(name string, data []byte) error {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	fmt.Fprintln(rt.out, shellJoin([]string{"mkdir", "-p", filepath.Dir(name)}))
+	fmt.Fprintf(rt.out, "cat > %s <<'DEVCLI_FILE'\n%s\nDEVCLI_FILE\n", shellJoin([]string{name}), strings.TrimRight(string(data), "\n"))
+	return nil
+}
+
+func (rt *dryRunRuntime) ComposeUp(project string, files []string, services []string) error {
+	rt.printCompose(project, files, append([]string{"up", "-d"}, services...)...)
+	rt.composeProjects[project] = true
+	return nil
+}
+
+func (rt *dryRunRuntime) ComposeBuild(project string, files []string, noCache bool) error {
+	args := []string{"build"}
+	if noCache {
+		args = append(args, "--no-cache")
+	}
+	rt.printCompose(project, files, args...)
+	return nil
+}
+
+func (rt *dryRunRuntime) ComposeStop(project string, files []string) error {
+	rt.printCompose(project, files, "stop")
+	return nil
+}
+
+func (rt *dryRunRuntime) ComposeDown(project string, files []string) error {
+	rt.printCompose(project, files, "down", "--remove-orphans")
+	rt.composeProjects[project] = false
+	return nil
+}
+
+func (rt *dryRunRuntime) ComposeContainer(project string, service string) (string, bool, error) {
+	up, ok := rt.composeProjects[project]
+	if !ok {
+		return rt.engineRuntime.ComposeContainer(project, service)
+	}
+	if !up {
+		return "", false, nil
+	}
+	// the project is only started by a printed command, the container is named like compose does
+	name := project + "-" + service + "-1"
+	rt.containers[name] = true
+	return name, true, nil
+}
+
+func (rt *dryRunRuntime) printCompose(project string, files []string, args ...string) {
+	rt.print(append([]string{rt.composeArgs[0]}, rt.composeCommandArgs(project, files, args...)...)...)
+}
+
+var shellSafe = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]+$`)
+
+// shellJoin quotes the arguments for a POSIX shell. Shell operators like "&&" are kept.
+func shellJoin(args []string) string {
+	quoted := make([]string, 0, len(args))
+	for _, arg := range args {
+		if shellSafe.MatchString(arg) || arg == "&&" {
+			quoted = append(quoted, arg)
+		} else {
+			quoted = append(quoted, "'"+strings.ReplaceAll(arg, "'", `'\''`)+"'")
+		}
+	}
+	return strings.Join(quoted, " ")
+}
@@ -0,0 +1,43 @@
+package docker
+
+import (
+	"fmt"
+)
+
+// RuntimeError is a failure of the container runtime, e.g. the daemon is not reachable or
+// rejected a request.
+type RuntimeError struct {
+	Err error
+}
+
+func (e *RuntimeError) Error() string {
+	return "container runtime: " + e.Err.Error()
+}
+
+func (e *RuntimeError) Unwrap() error {
+	return e.Err
+}
+
+// ExitError is returned if a command in the container or on the host exited with a non-zero code.
+type ExitError struct {
+	Cmd      []string
+	ExitCode int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("command %v exited with code %d", e.Cmd, e.ExitCode)
+}
+
+// LifecycleError is returned if a command of a lifecycle stage failed.
+type LifecycleError struct {
+	Stage string
+	Err   error
+}
+
+func (e *LifecycleError) Error() string {
+	return fmt.Sprintf("%s failed: %v", e.Stage, e.Err)
+}
+
+func (e *LifecycleError) Unwrap() error {
+	return e.Err
+}
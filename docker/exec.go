@@ -0,0 +1,169 @@
+package docker
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+
+	"golang.org/x/term"
+)
+
+// closeWriter is implemented by unix and tcp connections to signal the end of stdin.
+type closeWriter interface {
+	CloseWrite() error
+}
+
+// runExec creates an exec instance in the container, attaches to it and returns the exit code of the command.
+// The output of the command is written to stdout and stderr.
+func (c *client) runExec(containerName string, config execCreateConfig, stdout io.Writer, stderr io.Writer) (int, error) {
+	var created struct {
+		ID string `json:"Id"`
+	}
+	if err := c.doJSON("POST", "/containers/"+containerName+"/exec", nil, config, &created); err != nil {
+		return 0, err
+	}
+	conn, br, err := c.hijack("POST", "/exec/"+created.ID+"/start", execStartConfig{Tty: config.Tty})
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	if config.Tty && term.IsTerminal(int(os.Stdin.Fd())) {
+		state, err := term.MakeRaw(int(os.Stdin.Fd()))
+		if err != nil {
+			return 0, err
+		}
+		defer term.Restore(int(os.Stdin.Fd()), state)
+		stopResize := c.forwardResize(created.ID)
+		defer stopResize()
+	}
+
+	if config.AttachStdin {
+		done := make(chan struct{})
+		defer close(done)
+		go forwardStdin(conn, stdinChunks(), done)
+	}
+
+	if config.Tty {
+		_, err = io.Copy(stdout, br)
+	} else {
+		err = demuxStream(br, stdout, stderr)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	var inspect execInspect
+	if err := c.doJSON("GET", "/exec/"+created.ID+"/json", nil, nil, &inspect); err != nil {
+		return 0, err
+	}
+	return inspect.ExitCode, nil
+}
+
+var (
+	stdinOnce   sync.Once
+	stdinReader chan []byte
+)
+
+// stdinChunks returns the input read from os.Stdin. A read of os.Stdin cannot be interrupted,
+// so a single goroutine reads it for all exec sessions. Input read after a session ended is
+// kept for the next one instead of being lost in a goroutine still blocked in the read.
+func stdinChunks() <-chan []byte {
+	stdinOnce.Do(func() {
+		stdinReader = make(chan []byte)
+		go func() {
+			defer close(stdinReader)
+			for {
+				buf := make([]byte, 32*1024)
+				n, err := os.Stdin.Read(buf)
+				if n > 0 {
+					stdinReader <- buf[:n]
+				}
+				if err != nil {
+					return
+				}
+			}
+		}()
+	})
+	return stdinReader
+}
+
+// forwardStdin writes the input chunks to the exec connection until the session is done.
+// At the end of the input the write side of the connection is closed.
+func forwardStdin(conn io.Writer, chunks <-chan []byte, done <-chan struct{}) {
+	for {
+		select {
+		case <-done:
+			return
+		case chunk, ok := <-chunks:
+			if !ok {
+				if cw, ok := conn.(closeWriter); ok {
+					cw.CloseWrite()
+				}
+				return
+			}
+			if _, err := conn.Write(chunk); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// forwardResize keeps the tty size of the exec session in sync with the local terminal.
+func (c *client) forwardResize(execID string) func() {
+	resize := func() {
+		width, height, err := term.GetSize(int(os.Stdout.Fd()))
+		if err != nil {
+			return
+		}
+		query := url.Values{"h": {strconv.Itoa(height)}, "w": {strconv.Itoa(width)}}
+		if err := c.doJSON("POST", "/exec/"+execID+"/resize", query, nil, nil); err != nil {
+			logger.Debug().Err(err).Msg("could not resize exec tty")
+		}
+	}
+	resize()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGWINCH)
+	go func() {
+		for range signals {
+			resize()
+		}
+	}()
+	return func() {
+		signal.Stop(signals)
+		close(signals)
+	}
+}
+
+// demuxStream splits the multiplexed stdout/stderr stream of a non tty exec session.
+// Every frame starts with an 8 byte header: stream type, 3 bytes padding and the payload size.
+func demuxStream(r io.Reader, stdout io.Writer, stderr io.Writer) error {
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		var w io.Writer
+		switch header[0] {
+		case 0, 1:
+			w = stdout
+		case 2:
+			w = stderr
+		default:
+			return fmt.Errorf("invalid stream type %d", header[0])
+		}
+		size := int64(binary.BigEndian.Uint32(header[4:]))
+		if _, err := io.CopyN(w, r, size); err != nil {
+			return err
+		}
+	}
+}
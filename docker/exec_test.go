@@ -0,0 +1,135 @@
+package docker
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// frame encodes a payload of the multiplexed exec stream.
+func frame(stream byte, payload string) []byte {
+	header := make([]byte, 8)
+	header[0] = stream
+	binary.BigEndian.PutUint32(header[4:], uint32(len(payload)))
+	return append(header, payload...)
+}
+
+func TestDemuxStream(t *testing.T) {
+	var stream bytes.Buffer
+	stream.Write(frame(1, "out1 "))
+	stream.Write(frame(2, "err1"))
+	stream.Write(frame(1, "out2"))
+	stream.Write(frame(0, " stdin"))
+	var stdout, stderr strings.Builder
+	if err := demuxStream(&stream, &stdout, &stderr); err != nil {
+		t.Fatal(err)
+	}
+	if stdout.String() != "out1 out2 stdin" {
+		t.Errorf("unexpected stdout %q", stdout.String())
+	}
+	if stderr.String() != "err1" {
+		t.Errorf("unexpected stderr %q", stderr.String())
+	}
+}
+
+func TestDemuxStreamErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		stream []byte
+	}{
+		{"invalid stream type", frame(3, "x")},
+		{"truncated payload", frame(1, "payload")[:10]},
+		{"truncated header", frame(1, "x")[:4]},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := demuxStream(bytes.NewReader(test.stream), io.Discard, io.Discard); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestRunExec(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /"+apiVersion+"/containers/devc/exec", func(w http.ResponseWriter, r *http.Request) {
+		var config execCreateConfig
+		if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
+			t.Error(err)
+		}
+		if strings.Join(config.Cmd, " ") != "make test" || config.User != "1000:1000" {
+			t.Errorf("unexpected exec config %+v", config)
+		}
+		io.WriteString(w, `{"Id": "exec1"}`)
+	})
+	mux.HandleFunc("POST /"+apiVersion+"/exec/exec1/start", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "tcp" {
+			t.Errorf("exec start is not upgraded")
+		}
+		conn, buf, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		buf.WriteString("HTTP/1.1 101 UPGRADED\r\nContent-Type: application/vnd.docker.multiplexed-stream\r\nConnection: Upgrade\r\nUpgrade: tcp\r\n\r\n")
+		buf.Write(frame(1, "ok\n"))
+		buf.Write(frame(2, "warning\n"))
+		buf.Flush()
+	})
+	mux.HandleFunc("GET /"+apiVersion+"/exec/exec1/json", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"Running": false, "ExitCode": 3}`)
+	})
+	c := newTestClient(t, mux)
+
+	var stdout, stderr strings.Builder
+	config := execCreateConfig{AttachStdout: true, AttachStderr: true, User: "1000:1000", Cmd: []string{"make", "test"}}
+	exitCode, err := c.runExec("devc", config, &stdout, &stderr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exitCode != 3 {
+		t.Errorf("expected exit code 3, got %d", exitCode)
+	}
+	if stdout.String() != "ok\n" || stderr.String() != "warning\n" {
+		t.Errorf("unexpected output %q %q", stdout.String(), stderr.String())
+	}
+}
+
+func TestRunExecNotFound(t *testing.T) {
+	c := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		io.WriteString(w, `{"message": "No such container: devc"}`)
+	}))
+	_, err := c.runExec("devc", execCreateConfig{Cmd: []string{"true"}}, io.Discard, io.Discard)
+	if !isNotFound(err) {
+		t.Errorf("expected not found error, got %v", err)
+	}
+}
+
+func TestForwardStdinStopsWhenDone(t *testing.T) {
+	chunks := make(chan []byte)
+	done := make(chan struct{})
+	var forwarded strings.Builder
+	stopped := make(chan struct{})
+	go func() {
+		forwardStdin(&forwarded, chunks, done)
+		close(stopped)
+	}()
+	chunks <- []byte("ls\n")
+	close(done)
+	<-stopped
+	// the next input is left for the next session
+	select {
+	case chunks <- []byte("exit\n"):
+		t.Error("input was consumed after the session ended")
+	default:
+	}
+	if forwarded.String() != "ls\n" {
+		t.Errorf("expected forwarded input %q, got %q", "ls\n", forwarded.String())
+	}
+}
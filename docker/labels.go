@@ -0,0 +1,67 @@
+package docker
+
+import (
+	"time"
+
+	devcontainerspec "github.com/johndoe2991/devcli/devcontainer_spec"
+)
+
+// labels identify the containers and images created by devcli. Lookups use exact label
+// matches, so workspaces with similar names never match each other.
+const (
+	LabelWorkspace = "devcli.workspace"
+	LabelHash      = "devcli.config-hash"
+	// LabelConfig is the name of the config of the workspace, empty for the default config
+	LabelConfig  = "devcli.config"
+	LabelVersion = "devcli.version"
+	LabelCreated = "devcli.created"
+)
+
+// DevcliVersion is written to the labels, it is set by main.
+var DevcliVersion = "dev"
+
+// devcliLabels returns the labels of a new container or image of the devcontainer.
+func devcliLabels(devc devcontainerspec.Devcontainer) map[string]string {
+	return map[string]string{
+		LabelWorkspace: devc.Cwd,
+		LabelHash:      devc.Hash,
+		LabelConfig:    devc.ConfigName,
+		LabelVersion:   DevcliVersion,
+		LabelCreated:   time.Now().UTC().Format(time.RFC3339),
+	}
+}
+
+// workspaceLabels selects the resources of all configs of the workspace.
+func workspaceLabels(devc devcontainerspec.Devcontainer) map[string]string {
+	return map[string]string{LabelWorkspace: devc.Cwd}
+}
+
+// configLabels selects the resources of the current config of the workspace.
+func configLabels(devc devcontainerspec.Devcontainer) map[string]string {
+	return map[string]string{LabelWorkspace: devc.Cwd, LabelHash: devc.Hash}
+}
+
+// sameConfig reports whether labels belong to a version of the config of the devcontainer.
+// Resources without config label belong to the default config.
+func sameConfig(labels map[string]string, devc devcontainerspec.Devcontainer) bool {
+	return labels[LabelConfig] == devc.ConfigName
+}
+
+// anyLabels selects all resources created by devcli.
+func anyLabels() map[string]string {
+	return map[string]string{LabelWorkspace: ""}
+}
+
+// labelFilter converts labels into Engine API label filters. An empty value only requires
+// the label to exist.
+func labelFilter(labels map[string]string) []string {
+	filter := []string{}
+	for key, value := range labels {
+		if value == "" {
+			filter = append(filter, key)
+		} else {
+			filter = append(filter, key+"="+value)
+		}
+	}
+	return filter
+}
@@ -0,0 +1,286 @@
+package docker
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+
+	devcontainerspec "github.com/johndoe2991/devcli/devcontainer_spec"
+)
+
+// names of the lifecycle stages as used by the devcontainer spec and "waitFor"
+const (
+	stageInitialize    = "initializeCommand"
+	stageOnCreate      = "onCreateCommand"
+	stageUpdateContent = "updateContentCommand"
+	stagePostCreate    = "postCreateCommand"
+	stagePostStart     = "postStartCommand"
+	stagePostAttach    = "postAttachCommand"
+	// defaultWaitFor hands over the shell after all commands of a start finished,
+	// which is what a command line user expects
+	defaultWaitFor = stagePostStart
+)
+
+type lifecycleStage struct {
+	name     string
+	commands []devcontainerspec.LifecycleCommand
+	onHost   bool
+}
+
+// containerStages returns the lifecycle stages that run inside the container, depending on
+// whether the container was created or started by this devcli call.
+func containerStages(devc devcontainerspec.Devcontainer, created bool, started bool) []lifecycleStage {
+	stages := []lifecycleStage{}
+	if created {
+		stages = append(stages,
+			lifecycleStage{name: stageOnCreate, commands: devc.Config.OnCreateCommands},
+			lifecycleStage{name: stageUpdateContent, commands: devc.Config.UpdateContentCommands},
+			lifecycleStage{name: stagePostCreate, commands: devc.Config.PostCreateCommands},
+		)
+	}
+	if started {
+		stages = append(stages, lifecycleStage{name: stagePostStart, commands: devc.Config.PostStartCommands})
+	}
+	// postAttachCommand runs on every attach
+	return append(stages, lifecycleStage{name: stagePostAttach, commands: devc.Config.PostAttachCommands})
+}
+
+// splitAtWaitFor splits the stages into the ones that have to finish before the shell is handed
+// over and the ones that continue in the background.
+func splitAtWaitFor(stages []lifecycleStage, waitFor string) ([]lifecycleStage, []lifecycleStage) {
+	order := []string{stageInitialize, stageOnCreate, stageUpdateContent, stagePostCreate, stagePostStart}
+	if waitFor == "" {
+		waitFor = defaultWaitFor
+	}
+	waitIndex := -1
+	for i, name := range order {
+		if name == waitFor {
+			waitIndex = i
+		}
+	}
+	if waitIndex == -1 {
+		logger.Warn().Str("waitFor", waitFor).Str("default", defaultWaitFor).Msg("unknown waitFor value, using default")
+		return splitAtWaitFor(stages, defaultWaitFor)
+	}
+	foreground := []lifecycleStage{}
+	background := []lifecycleStage{}
+	for _, stage := range stages {
+		stageIndex := len(order)
+		for i, name := range order {
+			if name == stage.name {
+				stageIndex = i
+			}
+		}
+		if stageIndex <= waitIndex {
+			foreground = append(foreground, stage)
+		} else {
+			background = append(background, stage)
+		}
+	}
+	return foreground, background
+}
+
+// runLifecycleStage runs all commands of a stage one after another and logs the duration.
+// Output goes to w, or to the output of devcli if w is nil.
+func runLifecycleStage(rt Runtime, containerName string, devc devcontainerspec.Devcontainer, stage lifecycleStage, w io.Writer) error {
+	commands := []devcontainerspec.LifecycleCommand{}
+	for _, command := range stage.commands {
+		if !command.IsEmpty() {
+			commands = append(commands, command)
+		}
+	}
+	if len(commands) == 0 {
+		return nil
+	}
+	// in the background the shell is already open, so progress only goes to the log file
+	if w != nil {
+		fmt.Fprintf(w, "running %s\n", stage.name)
+	} else {
+		logger.Info().Str("stage", stage.name).Msg("running lifecycle commands")
+	}
+	start := time.Now()
+	for _, command := range commands {
+		logger.Debug().Str("container", containerName).Str("stage", stage.name).Stringer("command", command).Msg("executing lifecycle command")
+		var err error
+		if len(command.Parallel) > 0 {
+			err = runParallelCommands(rt, containerName, devc, stage, command, w)
+		} else {
+			err = runLifecycleCommand(rt, containerName, devc, stage, command, w)
+		}
+		if err != nil {
+			return &LifecycleError{Stage: stage.name, Err: err}
+		}
+	}
+	if w != nil {
+		fmt.Fprintf(w, "%s finished after %s\n", stage.name, time.Since(start))
+	} else {
+		logger.Info().Str("stage", stage.name).Dur("duration", time.Since(start)).Msg("lifecycle commands finished")
+	}
+	return nil
+}
+
+// runLifecycleCommand runs a string command with a shell and an array command without one.
+func runLifecycleCommand(rt Runtime, containerName string, devc devcontainerspec.Devcontainer, stage lifecycleStage, command devcontainerspec.LifecycleCommand, w io.Writer) error {
+	if stage.onHost {
+		args := command.Args
+		if command.Shell != "" {
+			args = []string{"/bin/sh", "-c", command.Shell}
+		}
+		if runner, ok := rt.(hostCommandRunner); ok {
+			return runner.RunHostCommand(devc.Cwd, args, w)
+		}
+		return runHostCommand(devc.Cwd, args, w)
+	}
+	args := command.Args
+	if command.Shell != "" {
+		args = []string{"/bin/bash", "-ic", command.Shell}
+	}
+	stdout := w
+	if stdout == nil {
+		stdout = output
+	}
+	return rt.ExecCommand(containerName, ExecOptions{
+		AsUser:     true,
+		User:       devc.GetRemoteUser(),
+		WorkingDir: devc.GetWorkspaceFolder(),
+		Env:        devc.Config.RemoteEnv,
+		Cmd:        args,
+		Stdout:     stdout,
+		Stderr:     w,
+	})
+}
+
+// runParallelCommands runs the entries of an object command in parallel. Every output line is
+// prefixed with the name of its entry. The stage fails if any entry fails.
+func runParallelCommands(rt Runtime, containerName string, devc devcontainerspec.Devcontainer, stage lifecycleStage, command devcontainerspec.LifecycleCommand, w io.Writer) error {
+	if w == nil {
+		w = output
+	}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	names := command.ParallelNames()
+	errs := make([]error, len(names))
+	for i, name := range names {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			out := &prefixWriter{prefix: "[" + name + "] ", w: w, mu: &mu}
+			err := runLifecycleCommand(rt, containerName, devc, stage, command.Parallel[name], out)
+			out.Flush()
+			if err != nil {
+				errs[i] = fmt.Errorf("%s: %w", name, err)
+			}
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// prefixWriter writes every complete line with a prefix. Writers sharing mu do not interleave lines.
+type prefixWriter struct {
+	prefix string
+	w      io.Writer
+	mu     *sync.Mutex
+	buf    []byte
+}
+
+func (p *prefixWriter) Write(data []byte) (int, error) {
+	p.buf = append(p.buf, data...)
+	for {
+		i := bytes.IndexByte(p.buf, '\n')
+		if i < 0 {
+			return len(data), nil
+		}
+		if err := p.writeLine(p.buf[:i+1]); err != nil {
+			return 0, err
+		}
+		p.buf = p.buf[i+1:]
+	}
+}
+
+// Flush writes a remaining incomplete line.
+func (p *prefixWriter) Flush() error {
+	if len(p.buf) == 0 {
+		return nil
+	}
+	line := append(p.buf, '\n')
+	p.buf = nil
+	return p.writeLine(line)
+}
+
+func (p *prefixWriter) writeLine(line []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, err := p.w.Write(append([]byte(p.prefix), line...))
+	return err
+}
+
+func runHostCommand(dir string, args []string, w io.Writer) error {
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Dir = dir
+	cmd.Stdout = output
+	cmd.Stderr = os.Stderr
+	if w != nil {
+		cmd.Stdout = w
+		cmd.Stderr = w
+	}
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return &ExitError{Cmd: args, ExitCode: exitErr.ExitCode()}
+	}
+	return err
+}
+
+// runBackgroundStages runs the stages after waitFor while the shell is open. Their output is
+// written to a log file, so it does not mix with the interactive session. The returned channel
+// receives the result once all stages finished.
+func runBackgroundStages(rt Runtime, containerName string, devc devcontainerspec.Devcontainer, stages []lifecycleStage) <-chan error {
+	done := make(chan error, 1)
+	hasCommands := false
+	for _, stage := range stages {
+		for _, command := range stage.commands {
+			hasCommands = hasCommands || !command.IsEmpty()
+		}
+	}
+	if !hasCommands {
+		done <- nil
+		return done
+	}
+	logFile, err := openLifecycleLog(containerName)
+	if err != nil {
+		done <- err
+		return done
+	}
+	logger.Info().Str("log", logFile.Name()).Msg("remaining lifecycle commands continue in the background")
+	go func() {
+		defer logFile.Close()
+		for _, stage := range stages {
+			if err := runLifecycleStage(rt, containerName, devc, stage, logFile); err != nil {
+				fmt.Fprintln(logFile, err)
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+	return done
+}
+
+func openLifecycleLog(containerName string) (*os.File, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Join(cacheDir, "devcli")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return os.Create(filepath.Join(dir, containerName+"-lifecycle.log"))
+}
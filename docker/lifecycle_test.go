@@ -0,0 +1,23 @@
+package docker
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRunHostCommandOutput(t *testing.T) {
+	var out strings.Builder
+	SetOutput(&out)
+	defer SetOutput(os.Stdout)
+	if err := runHostCommand(t.TempDir(), []string{"/bin/sh", "-c", "echo progress"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "progress\n" {
+		t.Errorf("output is not written to the progress output: %q", out.String())
+	}
+	err := runHostCommand(t.TempDir(), []string{"/bin/sh", "-c", "exit 4"}, nil)
+	if e, ok := err.(*ExitError); !ok || e.ExitCode != 4 {
+		t.Errorf("expected exit code 4, got %v", err)
+	}
+}
@@ -1,26 +1,244 @@
 package docker
 
 import (
-	"os/exec"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
 	"strings"
+	"text/tabwriter"
+	"time"
 )
 
-func listImage() ([]string, error) {
-	cmd := exec.Command("docker", "images", "--filter", "reference=devcli_*", "--format", "{{.Repository}}")
-	output, err := cmd.Output()
+// ContainerInfo describes a container created by devcli.
+type ContainerInfo struct {
+	Name    string
+	Image   string
+	State   string
+	Created time.Time
+	// Size is the size of the container including its image
+	Size   int64
+	Labels map[string]string
+}
+
+// ImageInfo describes an image created by devcli. Images without tag are named by their id.
+type ImageInfo struct {
+	Name    string
+	Created time.Time
+	Size    int64
+	Labels  map[string]string
+}
+
+// filterQuery encodes Engine API list filters, e.g. {"label": ["devcli.workspace"]}.
+func filterQuery(filters map[string][]string) (url.Values, error) {
+	data, err := json.Marshal(filters)
 	if err != nil {
-		return []string{}, err
+		return nil, err
+	}
+	return url.Values{"filters": {string(data)}}, nil
+}
+
+func (rt *engineRuntime) ListImages(labels map[string]string) ([]ImageInfo, error) {
+	query, err := filterQuery(map[string][]string{"label": labelFilter(labels)})
+	if err != nil {
+		return nil, err
+	}
+	var images []imageSummary
+	if err := rt.client.doJSON("GET", "/images/json", query, nil, &images); err != nil {
+		return nil, err
+	}
+	retval := []ImageInfo{}
+	for _, image := range images {
+		name := image.ID
+		if len(image.RepoTags) > 0 && image.RepoTags[0] != "<none>:<none>" {
+			name, _ = splitImageTag(image.RepoTags[0])
+		}
+		retval = append(retval, ImageInfo{Name: name, Created: time.Unix(image.Created, 0), Size: image.Size, Labels: image.Labels})
 	}
-	retval := strings.Split(string(output), "\n")
 	return retval, nil
 }
 
-func listContainers() ([]string, error) {
-	cmd := exec.Command("docker", "ps", "-a", "--filter", "name=devcli_*", "--format", "{{.Names}}")
-	output, err := cmd.Output()
+func (rt *engineRuntime) ListContainers(labels map[string]string) ([]ContainerInfo, error) {
+	query, err := filterQuery(map[string][]string{"label": labelFilter(labels)})
 	if err != nil {
-		return []string{}, err
+		return nil, err
+	}
+	query.Set("all", "true")
+	query.Set("size", "true")
+	var containers []containerSummary
+	if err := rt.client.doJSON("GET", "/containers/json", query, nil, &containers); err != nil {
+		return nil, err
+	}
+	retval := []ContainerInfo{}
+	for _, container := range containers {
+		info := ContainerInfo{
+			Name:    container.ID,
+			Image:   container.Image,
+			State:   container.State,
+			Created: time.Unix(container.Created, 0),
+			Size:    container.SizeRootFs,
+			Labels:  container.Labels,
+		}
+		if len(container.Names) > 0 {
+			info.Name = strings.TrimPrefix(container.Names[0], "/")
+		}
+		retval = append(retval, info)
 	}
-	retval := strings.Split(string(output), "\n")
 	return retval, nil
 }
+
+// ListEntry is a container or image in the output of the list command.
+type ListEntry struct {
+	Type      string     `json:"type"`
+	Name      string     `json:"name"`
+	Workspace string     `json:"workspace"`
+	Config    string     `json:"config,omitempty"`
+	Hash      string     `json:"hash"`
+	Image     string     `json:"image,omitempty"`
+	State     string     `json:"state,omitempty"`
+	Created   time.Time  `json:"created"`
+	LastUsed  *time.Time `json:"lastUsed,omitempty"`
+	Size      int64      `json:"size"`
+	// Stale is set for resources of a workspace whose current config has another hash
+	Stale bool `json:"stale"`
+}
+
+// List returns all containers and images created by devcli. currentHash returns the hash of
+// the current version of a config of a workspace, or false if it is unknown.
+func List(rt Runtime, currentHash func(workspace string, config string) (string, bool)) ([]ListEntry, error) {
+	containers, err := rt.ListContainers(anyLabels())
+	if err != nil {
+		return nil, err
+	}
+	images, err := rt.ListImages(anyLabels())
+	if err != nil {
+		return nil, err
+	}
+	type workspaceConfig struct{ workspace, config string }
+	hashes := map[workspaceConfig]string{}
+	known := map[workspaceConfig]bool{}
+	isStale := func(labels map[string]string) bool {
+		key := workspaceConfig{labels[LabelWorkspace], labels[LabelConfig]}
+		if _, ok := known[key]; !ok {
+			hashes[key], known[key] = currentHash(key.workspace, key.config)
+		}
+		return known[key] && hashes[key] != labels[LabelHash]
+	}
+	entries := []ListEntry{}
+	for _, container := range containers {
+		entry := ListEntry{
+			Type:      "container",
+			Name:      container.Name,
+			Workspace: container.Labels[LabelWorkspace],
+			Config:    container.Labels[LabelConfig],
+			Hash:      container.Labels[LabelHash],
+			Image:     container.Image,
+			State:     container.State,
+			Created:   createdTime(container.Labels, container.Created),
+			Size:      container.Size,
+			Stale:     isStale(container.Labels),
+		}
+		state, err := rt.ContainerState(container.Name)
+		if err != nil {
+			return nil, err
+		}
+		// a running container is in use right now
+		lastUsed := state.StartedAt
+		if state.Running {
+			lastUsed = time.Now()
+		} else if state.FinishedAt.After(lastUsed) {
+			lastUsed = state.FinishedAt
+		}
+		if !lastUsed.IsZero() {
+			entry.LastUsed = &lastUsed
+		}
+		entries = append(entries, entry)
+	}
+	for _, image := range images {
+		entries = append(entries, ListEntry{
+			Type:      "image",
+			Name:      image.Name,
+			Workspace: image.Labels[LabelWorkspace],
+			Config:    image.Labels[LabelConfig],
+			Hash:      image.Labels[LabelHash],
+			Created:   createdTime(image.Labels, image.Created),
+			Size:      image.Size,
+			Stale:     isStale(image.Labels),
+		})
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].Workspace != entries[j].Workspace {
+			return entries[i].Workspace < entries[j].Workspace
+		}
+		return entries[i].Created.After(entries[j].Created)
+	})
+	return entries, nil
+}
+
+// createdTime prefers the creation time of the label, which is the time devcli created the
+// resource, over the time reported by the runtime.
+func createdTime(labels map[string]string, created time.Time) time.Time {
+	if t, err := time.Parse(time.RFC3339, labels[LabelCreated]); err == nil {
+		return t
+	}
+	return created
+}
+
+// PrintList writes the entries as table, or as JSON for scripts.
+func PrintList(w io.Writer, entries []ListEntry, asJSON bool) error {
+	if asJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(entries)
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TYPE\tNAME\tWORKSPACE\tCONFIG\tHASH\tIMAGE\tSTATE\tCREATED\tLAST USED\tSIZE\tSTALE")
+	for _, entry := range entries {
+		lastUsed := "-"
+		if entry.LastUsed != nil {
+			lastUsed = formatTime(*entry.LastUsed)
+		}
+		stale := ""
+		if entry.Stale {
+			stale = "yes"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			entry.Type, entry.Name, entry.Workspace, orDash(entry.Config), shortHash(entry.Hash), orDash(entry.Image), orDash(entry.State),
+			formatTime(entry.Created), lastUsed, formatSize(entry.Size), stale)
+	}
+	return tw.Flush()
+}
+
+func shortHash(hash string) string {
+	if len(hash) > 7 {
+		return hash[:7]
+	}
+	return orDash(hash)
+}
+
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+func formatTime(t time.Time) string {
+	return t.Local().Format("2006-01-02 15:04")
+}
+
+// formatSize formats a size in bytes with a decimal unit like docker does.
+func formatSize(size int64) string {
+	units := []string{"B", "kB", "MB", "GB", "TB"}
+	value := float64(size)
+	unit := 0
+	for value >= 1000 && unit < len(units)-1 {
+		value /= 1000
+		unit++
+	}
+	if unit == 0 {
+		return fmt.Sprintf("%d%s", size, units[0])
+	}
+	return fmt.Sprintf("%.1f%s", value, units[unit])
+}
@@ -1,5 +1,19 @@
 package docker
 
-import "github.com/johndoe2991/devcli/logging"
+import (
+	"io"
+	"os"
+
+	"github.com/johndoe2991/devcli/logging"
+)
 
 var logger = logging.GetLogger("docker")
+
+// output receives the progress of devcli: the output of builds, compose and lifecycle commands.
+var output io.Writer = os.Stdout
+
+// SetOutput changes where the progress of devcli is written to, e.g. to os.Stderr to keep
+// stdout free for the output of a command.
+func SetOutput(w io.Writer) {
+	output = w
+}
@@ -0,0 +1,155 @@
+package docker
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// readinessTimeout is the maximum time to wait for a started container to accept commands.
+	readinessTimeout   = 60 * time.Second
+	readinessFirstWait = 50 * time.Millisecond
+	readinessMaxWait   = time.Second
+	// crashLogLines is the number of log lines shown when a container stopped right after its start.
+	crashLogLines = 20
+)
+
+// ContainerState is the state of a container as reported by the runtime.
+type ContainerState struct {
+	Status     string
+	Running    bool
+	ExitCode   int
+	Error      string
+	StartedAt  time.Time
+	FinishedAt time.Time
+}
+
+func (rt *engineRuntime) ContainerState(containerName string) (ContainerState, error) {
+	inspect, err := rt.inspectContainer(containerName)
+	if err != nil {
+		return ContainerState{}, err
+	}
+	if inspect == nil {
+		return ContainerState{}, fmt.Errorf("container %s does not exist", containerName)
+	}
+	return ContainerState{
+		Status:     inspect.State.Status,
+		Running:    inspect.State.Running,
+		ExitCode:   inspect.State.ExitCode,
+		Error:      inspect.State.Error,
+		StartedAt:  inspect.State.StartedAt,
+		FinishedAt: inspect.State.FinishedAt,
+	}, nil
+}
+
+// ContainerEnv returns the environment the container was created with.
+func (rt *engineRuntime) ContainerEnv(containerName string) (map[string]string, error) {
+	inspect, err := rt.inspectContainer(containerName)
+	if err != nil {
+		return nil, err
+	}
+	if inspect == nil {
+		return nil, fmt.Errorf("container %s does not exist", containerName)
+	}
+	env := map[string]string{}
+	for _, entry := range inspect.Config.Env {
+		key, value, _ := strings.Cut(entry, "=")
+		env[key] = value
+	}
+	return env, nil
+}
+
+// ContainerLogs returns the last lines of the container output.
+func (rt *engineRuntime) ContainerLogs(containerName string, lines int) (string, error) {
+	query := url.Values{
+		"stdout": {"1"},
+		"stderr": {"1"},
+		"tail":   {strconv.Itoa(lines)},
+	}
+	req, err := rt.client.newRequest("GET", "/containers/"+containerName+"/logs", query, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := rt.client.do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var logs bytes.Buffer
+	if resp.Header.Get("Content-Type") == "application/vnd.docker.multiplexed-stream" {
+		err = demuxStream(resp.Body, &logs, &logs)
+	} else {
+		_, err = io.Copy(&logs, resp.Body)
+	}
+	return logs.String(), err
+}
+
+// ProbeExec runs a trivial command in the container to check that it accepts exec sessions.
+func (rt *engineRuntime) ProbeExec(containerName string) error {
+	config := execCreateConfig{
+		AttachStdout: true,
+		AttachStderr: true,
+		Cmd:          []string{"true"},
+	}
+	exitCode, err := rt.client.runExec(containerName, config, io.Discard, io.Discard)
+	if err != nil {
+		return err
+	}
+	if exitCode != 0 {
+		return fmt.Errorf("probe exited with code %d", exitCode)
+	}
+	return nil
+}
+
+// waitForContainer polls the container state until it is running and then probes it with a
+// trivial exec, both with exponential backoff. A container that stopped is reported with its
+// exit code and the last lines of its output.
+func waitForContainer(rt Runtime, containerName string, timeout time.Duration) error {
+	start := time.Now()
+	deadline := start.Add(timeout)
+	wait := readinessFirstWait
+	backoff := func() {
+		time.Sleep(wait)
+		wait = min(2*wait, readinessMaxWait)
+	}
+	for {
+		state, err := rt.ContainerState(containerName)
+		if err != nil {
+			return err
+		}
+		if !state.Running && state.Status != "created" && state.Status != "restarting" {
+			return containerStoppedError(rt, containerName, state)
+		}
+		if state.Running {
+			if err = rt.ProbeExec(containerName); err == nil {
+				logger.Debug().Str("container", containerName).Dur("duration", time.Since(start)).Msg("container is ready")
+				return nil
+			}
+			logger.Debug().Err(err).Str("container", containerName).Msg("container does not accept commands yet")
+		}
+		if time.Now().After(deadline) {
+			if err != nil {
+				return fmt.Errorf("container %s is not ready after %s: %w", containerName, timeout, err)
+			}
+			return fmt.Errorf("container %s is not running after %s (status %s)", containerName, timeout, state.Status)
+		}
+		backoff()
+	}
+}
+
+func containerStoppedError(rt Runtime, containerName string, state ContainerState) error {
+	msg := fmt.Sprintf("container %s stopped right after its start (status %s, exit code %d)", containerName, state.Status, state.ExitCode)
+	if state.Error != "" {
+		msg += ": " + state.Error
+	}
+	logs, err := rt.ContainerLogs(containerName, crashLogLines)
+	if err == nil && strings.TrimSpace(logs) != "" {
+		msg += "\ncontainer output:\n" + strings.TrimRight(logs, "\n")
+	}
+	return fmt.Errorf("%s", msg)
+}
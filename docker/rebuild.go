@@ -0,0 +1,21 @@
+package docker
+
+import (
+	devcontainerspec "github.com/johndoe2991/devcli/devcontainer_spec"
+)
+
+// Rebuild removes the container and the images of the current config. The image is built again
+// by the following Run with the same options, compose images are built here, because compose
+// does not build existing images on start.
+func Rebuild(rt Runtime, devc devcontainerspec.Devcontainer, opts BuildOptions) error {
+	if devc.IsCompose() {
+		if err := CleanCompose(rt, devc); err != nil {
+			return err
+		}
+		return rt.ComposeBuild(devc.GetComposeProject(), devc.Config.ComposeFiles, opts.NoCache)
+	}
+	if err := CleanContainer(rt, devc.GetContainerName()); err != nil {
+		return err
+	}
+	return CleanConfigImages(rt, devc)
+}
@@ -0,0 +1,134 @@
+package docker
+
+import (
+	"archive/tar"
+	"io"
+	"os"
+	"regexp"
+	"runtime"
+	"strconv"
+
+	devcontainerspec "github.com/johndoe2991/devcli/devcontainer_spec"
+)
+
+// updateUIDDockerfile changes the uid and gid of REMOTE_USER to NEW_UID and NEW_GID and hands
+// the home directory over. It keeps the user unchanged if the ids are taken by another user.
+const updateUIDDockerfile = `ARG BASE_IMAGE
+FROM $BASE_IMAGE
+USER root
+ARG REMOTE_USER
+ARG NEW_UID
+ARG NEW_GID
+SHELL ["/bin/sh", "-c"]
+RUN eval $(sed -n "s/^${REMOTE_USER}:[^:]*:\([^:]*\):\([^:]*\):[^:]*:\([^:]*\).*/OLD_UID=\1;OLD_GID=\2;HOME_FOLDER=\3/p" /etc/passwd); \
+	eval $(sed -n "s/^\([^:]*\):[^:]*:${NEW_UID}:.*/EXISTING_USER=\1/p" /etc/passwd); \
+	eval $(sed -n "s/^\([^:]*\):[^:]*:${NEW_GID}:.*/EXISTING_GROUP=\1/p" /etc/group); \
+	if [ -z "$OLD_UID" ]; then \
+		echo "remote user $REMOTE_USER not found in /etc/passwd"; \
+	elif [ "$OLD_UID" = "$NEW_UID" -a "$OLD_GID" = "$NEW_GID" ]; then \
+		echo "uid and gid of $REMOTE_USER already match"; \
+	elif [ "$OLD_UID" != "$NEW_UID" -a -n "$EXISTING_USER" ]; then \
+		echo "uid $NEW_UID is already used by $EXISTING_USER"; \
+	else \
+		if [ "$OLD_GID" != "$NEW_GID" -a -n "$EXISTING_GROUP" ]; then \
+			echo "gid $NEW_GID is already used by $EXISTING_GROUP, keeping gid $OLD_GID"; \
+			NEW_GID="$OLD_GID"; \
+		fi; \
+		echo "updating $REMOTE_USER from $OLD_UID:$OLD_GID to $NEW_UID:$NEW_GID"; \
+		sed -i -e "s/^\(${REMOTE_USER}:[^:]*:\)[^:]*:[^:]*/\1${NEW_UID}:${NEW_GID}/" /etc/passwd; \
+		if [ "$OLD_GID" != "$NEW_GID" ]; then \
+			sed -i -e "s/^\([^:]*:[^:]*:\)${OLD_GID}:/\1${NEW_GID}:/" /etc/group; \
+		fi; \
+		chown -R $NEW_UID:$NEW_GID $HOME_FOLDER; \
+	fi
+ARG IMAGE_USER
+USER $IMAGE_USER
+`
+
+var numericUser = regexp.MustCompile(`^[0-9]+(:[0-9]+)?$`)
+
+// updatesRemoteUserUID reports whether the container runs from an image in which the uid of
+// the remote user is changed to the uid of the host user, so files in bind mounts keep their
+// ownership. Like in the reference implementation this is only done on linux, other platforms
+// map the ownership in the VM of docker. Podman maps the host user with "keep-id" instead.
+func updatesRemoteUserUID(rt Runtime, devc devcontainerspec.Devcontainer) bool {
+	if devc.Config.UpdateRemoteUserUID != nil && !*devc.Config.UpdateRemoteUserUID {
+		return false
+	}
+	remoteUser := devc.GetRemoteUser()
+	if remoteUser == "" || remoteUser == "root" || numericUser.MatchString(remoteUser) {
+		return false
+	}
+	return runtime.GOOS == "linux" && rt.Name() == RuntimeDocker && os.Getuid() != 0
+}
+
+// containerImageName returns the image the container is created from.
+func containerImageName(rt Runtime, devc devcontainerspec.Devcontainer) string {
+	if updatesRemoteUserUID(rt, devc) {
+		return devc.GetRemoteUserImageName()
+	}
+	return devc.GetImageName()
+}
+
+func (rt *engineRuntime) BuildRemoteUserImage(devc devcontainerspec.Devcontainer) error {
+	params, err := rt.remoteUserBuildParams(devc)
+	if err != nil {
+		return err
+	}
+	query, err := params.query()
+	if err != nil {
+		return err
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(writeDockerfileContext(pw, updateUIDDockerfile))
+	}()
+	defer pr.Close()
+	return rt.build(query, pr)
+}
+
+// remoteUserBuildParams returns the parameters to build updateUIDDockerfile for the devcontainer.
+func (rt *engineRuntime) remoteUserBuildParams(devc devcontainerspec.Devcontainer) (buildParams, error) {
+	baseImage := devc.GetImageName()
+	// the derived image has to run with the user of the base image
+	var base imageInspect
+	if err := rt.client.doJSON("GET", "/images/"+baseImage+"/json", nil, nil, &base); err != nil {
+		return buildParams{}, err
+	}
+	return newRemoteUserBuildParams(devc, base.Config.User), nil
+}
+
+// newRemoteUserBuildParams returns the parameters to build updateUIDDockerfile on top of the
+// image of the devcontainer, which runs as imageUser.
+func newRemoteUserBuildParams(devc devcontainerspec.Devcontainer, imageUser string) buildParams {
+	baseImage := devc.GetImageName()
+	return buildParams{
+		imageName:  devc.GetRemoteUserImageName(),
+		dockerfile: dockerfileName,
+		buildArgs: map[string]string{
+			"BASE_IMAGE":  baseImage,
+			"REMOTE_USER": devc.GetRemoteUser(),
+			"NEW_UID":     strconv.Itoa(os.Getuid()),
+			"NEW_GID":     strconv.Itoa(os.Getgid()),
+			"IMAGE_USER":  imageUser,
+		},
+		labels: devcliLabels(devc),
+	}
+}
+
+// writeDockerfileContext writes a build context that only contains the Dockerfile.
+func writeDockerfileContext(w io.Writer, dockerfile string) error {
+	tw := tar.NewWriter(w)
+	header := &tar.Header{
+		Name: dockerfileName,
+		Mode: 0644,
+		Size: int64(len(dockerfile)),
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(tw, dockerfile); err != nil {
+		return err
+	}
+	return tw.Close()
+}
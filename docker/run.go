@@ -1,171 +1,269 @@
 package docker
 
 import (
+	"fmt"
+	"net/url"
 	"os"
-	"os/exec"
-	"os/user"
-	"path"
 	"path/filepath"
-	"time"
 
 	devcontainerspec "github.com/johndoe2991/devcli/devcontainer_spec"
+	"golang.org/x/term"
 )
 
-func Run(devc devcontainerspec.Devcontainer) error {
-	containerName := devc.GetContainerName()
-	// first check if a container is already running
-	running, err := checkContainerRunning(containerName)
+// Run starts the devcontainer and opens an interactive shell in it. opts are used if the
+// image has to be built.
+func Run(rt Runtime, devc devcontainerspec.Devcontainer, opts BuildOptions) error {
+	return execInDevcontainer(rt, devc, []string{"/bin/bash"}, true, opts)
+}
+
+// Exec starts the devcontainer like Run and executes a single command in the workspace folder.
+// Stdin is always forwarded, so input can be piped into the command. A tty is only allocated
+// if stdin is a terminal, so the output can be piped.
+func Exec(rt Runtime, devc devcontainerspec.Devcontainer, cmd []string) error {
+	return execInDevcontainer(rt, devc, cmd, term.IsTerminal(int(os.Stdin.Fd())), BuildOptions{})
+}
+
+// execInDevcontainer makes sure the container is running and its lifecycle commands ran, and
+// executes cmd in it as a session of the container with the stdin of devcli.
+func execInDevcontainer(rt Runtime, devc devcontainerspec.Devcontainer, cmd []string, tty bool, opts BuildOptions) error {
+	// initializeCommand runs on the host on every start, before anything is built
+	if err := runLifecycleStage(rt, "", devc, lifecycleStage{name: stageInitialize, commands: devc.Config.InitializeCommands, onHost: true}, nil); err != nil {
+		return err
+	}
+	// the session is registered before the container is started, so a session that ends in the
+	// meantime does not shut down the container this one is about to use. A dry run leaves no
+	// session files behind and never applies the shutdownAction.
+	var sess *session
+	if !isDryRun(rt) {
+		var err error
+		sess, err = startSession(sessionName(devc))
+		if err != nil {
+			return fmt.Errorf("could not register session: %w", err)
+		}
+	}
+	containerName, devc, backgroundDone, err := prepareDevcontainer(rt, devc, opts)
 	if err != nil {
+		sess.end()
 		return err
 	}
-	if running {
-		// container is running, nothing to do
+	// exec into the container
+	logger.Debug().Str("container", containerName).Strs("cmd", cmd).Msg("exec into container")
+	err = rt.ExecCommand(containerName, ExecOptions{AttachStdin: true, Tty: tty, AsUser: true, User: devc.GetRemoteUser(), WorkingDir: devc.GetWorkspaceFolder(), Env: devc.Config.RemoteEnv, Cmd: cmd})
+	if err == nil {
+		err = <-backgroundDone
+	}
+	last, sessErr := sess.end()
+	if sessErr != nil {
+		logger.Warn().Err(sessErr).Str("container", containerName).Msg("could not check for other sessions")
+	} else if last {
+		if shutdownErr := shutdown(rt, devc, containerName); shutdownErr != nil {
+			logger.Warn().Err(shutdownErr).Str("container", containerName).Msg("could not shut down container")
+		}
+	}
+	return err
+}
+
+// prepareDevcontainer starts the container, waits until it is ready and runs the lifecycle
+// stages before waitFor. It returns the container, the devcontainer with the values of the
+// container environment and the result of the stages running in the background.
+func prepareDevcontainer(rt Runtime, devc devcontainerspec.Devcontainer, opts BuildOptions) (string, devcontainerspec.Devcontainer, <-chan error, error) {
+	var containerName string
+	var created, started bool
+	var err error
+	if devc.IsCompose() {
+		containerName, created, started, err = startComposeService(rt, devc)
 	} else {
-		// check if a container already exists
-		exists, err := checkContainerExists(containerName)
-		if err != nil {
-			return err
+		containerName = devc.GetContainerName()
+		created, started, err = startDevcontainer(rt, devc, opts)
+	}
+	if err != nil {
+		return "", devc, nil, err
+	}
+	if started {
+		if err := waitForContainer(rt, containerName, readinessTimeout); err != nil {
+			return "", devc, nil, err
 		}
-		logger.Debug().Str("container", containerName).Bool("exists", exists).Msg("checking if container exists")
-		if exists {
-			// container exists, we start it
-			if err := startContainer(containerName); err != nil {
-				return err
-			}
-		} else {
-			// container does not exist, we create it
-			if err := Build(devc); err != nil {
-				return err
-			}
-			if err := createAndStartContainer(devc); err != nil {
-				return err
-			}
-			// first run, so we have to exec postCreateCommand
-			time.Sleep(2 * time.Second) // wait for the container to be ready
-			for _, postCreateCommand := range devc.Config.PostCreateCommands {
-				if postCreateCommand == "" {
-					continue
-				}
-				// exec into the container
-				if err := execCommand(containerName, false, true, "", []string{"/bin/bash", "-ic", postCreateCommand}); err != nil {
-					return err
-				}
-			}
+	}
+	// values in the container can refer to the environment of the container
+	containerEnv, err := rt.ContainerEnv(containerName)
+	if err != nil {
+		return "", devc, nil, err
+	}
+	devc = devc.WithContainerEnv(containerEnv)
+	foreground, background := splitAtWaitFor(containerStages(devc, created, started), devc.Config.WaitFor)
+	for _, stage := range foreground {
+		if err := runLifecycleStage(rt, containerName, devc, stage, nil); err != nil {
+			return "", devc, nil, err
 		}
-		time.Sleep(300 * time.Millisecond) // wait for the container to be ready
-		for _, postStartCommand := range devc.Config.PostStartCommands {
-			// exec into the container
-			if postStartCommand == "" {
-				continue
-			}
-			logger.Debug().Str("container", containerName).Str("postStartCommand", postStartCommand).Msg("executing postStartCommand")
-			if err := execCommand(containerName, false, true, "", []string{"/bin/bash", "-ic", postStartCommand}); err != nil {
-				return err
-			}
+	}
+	return containerName, devc, runBackgroundStages(rt, containerName, devc, background), nil
+}
+
+// startDevcontainer makes sure the container is running and returns whether it was
+// created or started by this call.
+func startDevcontainer(rt Runtime, devc devcontainerspec.Devcontainer, opts BuildOptions) (bool, bool, error) {
+	containerName := devc.GetContainerName()
+	// first check if a container is already running
+	running, err := rt.ContainerRunning(containerName)
+	if err != nil {
+		return false, false, err
+	}
+	if running {
+		// container is running, nothing to do
+		return false, false, nil
+	}
+	// check if a container already exists
+	exists, err := rt.ContainerExists(containerName)
+	if err != nil {
+		return false, false, err
+	}
+	logger.Debug().Str("container", containerName).Bool("exists", exists).Msg("checking if container exists")
+	if exists {
+		// container exists, we start it
+		if err := rt.StartContainer(containerName); err != nil {
+			return false, false, err
 		}
+		return false, true, nil
 	}
-	// exec into the container
-	time.Sleep(1 * time.Second) // wait for the container to be ready
-	logger.Debug().Str("container", containerName).Msg("exec into container")
-	if err := execCommand(containerName, true, true, "/workspaces/"+path.Base(devc.Cwd), []string{"/bin/bash"}); err != nil {
-		return err
+	// container does not exist, we create it
+	if err := Build(rt, devc, opts); err != nil {
+		return false, false, err
 	}
-	return nil
+	if err := rt.CreateAndStartContainer(devc); err != nil {
+		return false, false, err
+	}
+	return true, true, nil
 }
 
-func checkContainerRunning(containerName string) (bool, error) {
-	cmd := exec.Command("docker", "ps", "-q", "-f", "name="+containerName)
-	output, err := cmd.Output()
+// inspectContainer returns nil without error if the container does not exist.
+func (rt *engineRuntime) inspectContainer(containerName string) (*containerInspect, error) {
+	var inspect containerInspect
+	err := rt.client.doJSON("GET", "/containers/"+containerName+"/json", nil, nil, &inspect)
+	if isNotFound(err) {
+		return nil, nil
+	}
 	if err != nil {
-		return false, err
+		return nil, err
 	}
-	return len(output) > 0, nil
+	return &inspect, nil
 }
 
-func checkContainerExists(containerName string) (bool, error) {
-	cmd := exec.Command("docker", "ps", "-aq", "-f", "name="+containerName)
-	output, err := cmd.Output()
+func (rt *engineRuntime) ContainerRunning(containerName string) (bool, error) {
+	inspect, err := rt.inspectContainer(containerName)
 	if err != nil {
 		return false, err
 	}
-	return len(output) > 0, nil
+	return inspect != nil && inspect.State.Running, nil
 }
 
-func startContainer(containerName string) error {
-	// start the container
-	cmd := exec.Command("docker", "start", containerName)
-
-	logger.Debug().Str("container", containerName).Msg("starting container")
-	err := cmd.Run()
+func (rt *engineRuntime) ContainerExists(containerName string) (bool, error) {
+	inspect, err := rt.inspectContainer(containerName)
 	if err != nil {
-		return err
+		return false, err
 	}
-	return nil
+	return inspect != nil, nil
 }
 
-func stopContainer(containerName string) error {
-	// stop the container
-	cmd := exec.Command("docker", "stop", containerName)
+func (rt *engineRuntime) StartContainer(containerName string) error {
+	logger.Debug().Str("container", containerName).Msg("starting container")
+	return rt.client.doJSON("POST", "/containers/"+containerName+"/start", nil, nil, nil)
+}
 
+func (rt *engineRuntime) StopContainer(containerName string) error {
 	logger.Debug().Str("container", containerName).Msg("stopping container")
-	err := cmd.Run()
-	if err != nil {
-		return err
-	}
-	return nil
+	return rt.client.doJSON("POST", "/containers/"+containerName+"/stop", nil, nil, nil)
 }
 
-func createAndStartContainer(devc devcontainerspec.Devcontainer) error {
-	// run the container
-	cwd, err := os.Getwd()
-	if err != nil {
+func (rt *engineRuntime) CreateAndStartContainer(devc devcontainerspec.Devcontainer) error {
+	imageName := containerImageName(rt, devc)
+	config := containerCreateConfig{
+		Image: imageName,
+		User:  devc.Config.ContainerUser,
+		//we keep the container running with a sleep so we can exec into it later
+		Cmd: []string{"/bin/bash", "-c", "while true; do sleep 5; done;"},
+		Env: devcontainerspec.EnvList(devc.Config.ContainerEnv),
+		HostConfig: hostConfig{
+			UsernsMode: rt.usernsMode,
+		},
+	}
+	// the workspace is bind mounted to /workspaces/<name> unless the config sets its own mount
+	if devc.Config.WorkspaceMount != "" {
+		m, err := parseMount(devc.Config.WorkspaceMount)
+		if err != nil {
+			return fmt.Errorf("invalid workspaceMount: %w", err)
+		}
+		config.HostConfig.Mounts = append(config.HostConfig.Mounts, m)
+	} else {
+		config.HostConfig.Binds = []string{devc.Cwd + ":/workspaces/" + filepath.Base(devc.Cwd)}
+	}
+	for _, mountSpec := range devc.Config.Mounts {
+		m, err := parseMount(mountSpec)
+		if err != nil {
+			return err
+		}
+		config.HostConfig.Mounts = append(config.HostConfig.Mounts, m)
+	}
+	if err := applyRunArgs(&config, devc.Config.RunArgs); err != nil {
 		return err
 	}
-	args := []string{"run", "-d", "--name", devc.GetContainerName(), "--volume", cwd + ":/workspaces/" + filepath.Base(cwd)}
-	for _, mount := range devc.Config.Mounts {
-		args = append(args, "--mount", mount)
-	}
-	args = append(args, devc.Config.RunArgs...)
-	imageName := devc.GetImageName()
-	//we keep the container running with a sleep so we can exec into it later
-	args = append(args, imageName, "/bin/bash", "-c", "while true; do sleep 5; done;")
-	cmd := exec.Command("docker", args...)
-	logger.Debug().Str("image", imageName).Strs("args", args).Msg("running image")
-	err = cmd.Run()
-	if err != nil {
+	if config.Labels == nil {
+		config.Labels = map[string]string{}
+	}
+	for key, value := range devcliLabels(devc) {
+		config.Labels[key] = value
+	}
+	logger.Debug().Str("image", imageName).Interface("config", config).Msg("running image")
+	var created containerCreateResponse
+	query := url.Values{"name": {devc.GetContainerName()}}
+	if err := rt.client.doJSON("POST", "/containers/create", query, config, &created); err != nil {
 		return err
 	}
-	return nil
+	for _, warning := range created.Warnings {
+		logger.Warn().Str("container", devc.GetContainerName()).Msg(warning)
+	}
+	return rt.client.doJSON("POST", "/containers/"+created.ID+"/start", nil, nil, nil)
 }
 
-func execCommand(containerName string, interactive bool, asUser bool, workingDir string, args []string) error {
-	// exec into the container
-	cmdargs := []string{"exec"}
-	if interactive {
-		cmdargs = append(cmdargs, "-it")
+// execUserFor returns the user to execute a command with, an empty user leaves the choice to the container.
+func (rt *engineRuntime) execUserFor(opts ExecOptions) (string, error) {
+	if !opts.AsUser {
+		return "", nil
 	}
-	if asUser {
-		currentUser, err := user.Current()
-		if err != nil {
-			return err
-		}
-		cmdargs = append(cmdargs, "--user", currentUser.Uid+":"+currentUser.Gid)
+	if opts.User != "" {
+		return opts.User, nil
+	}
+	return rt.execUser()
+}
+
+func (rt *engineRuntime) ExecCommand(containerName string, opts ExecOptions) error {
+	config := execCreateConfig{
+		AttachStdin:  opts.AttachStdin,
+		AttachStdout: true,
+		AttachStderr: true,
+		Tty:          opts.Tty,
+		WorkingDir:   opts.WorkingDir,
+		Env:          devcontainerspec.EnvList(opts.Env),
+		Cmd:          opts.Cmd,
 	}
-	if workingDir != "" {
-		cmdargs = append(cmdargs, "-w", workingDir)
+	execUser, err := rt.execUserFor(opts)
+	if err != nil {
+		return err
 	}
-	cmdargs = append(cmdargs, containerName)
-	cmdargs = append(cmdargs, args...)
-	cmd := exec.Command("docker", cmdargs...)
-	if interactive {
-		cmd.Stdin = os.Stdin
+	config.User = execUser
+	stdout, stderr := opts.Stdout, opts.Stderr
+	if stdout == nil {
+		stdout = os.Stdout
 	}
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	logger.Debug().Str("container", containerName).Bool("interactive", interactive).Bool("asUser", asUser).Str("workingDir", workingDir).Strs("args", args).Msg("executing command in container")
-	err := cmd.Run()
+	if stderr == nil {
+		stderr = os.Stderr
+	}
+	logger.Debug().Str("container", containerName).Bool("stdin", opts.AttachStdin).Bool("tty", opts.Tty).Bool("asUser", opts.AsUser).Str("workingDir", opts.WorkingDir).Strs("args", opts.Cmd).Msg("executing command in container")
+	exitCode, err := rt.client.runExec(containerName, config, stdout, stderr)
 	if err != nil {
 		return err
 	}
+	if exitCode != 0 {
+		return &ExitError{Cmd: opts.Cmd, ExitCode: exitCode}
+	}
 	return nil
 }
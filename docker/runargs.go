@@ -0,0 +1,359 @@
+package docker
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parseMount converts a mount in the "--mount" syntax (type=bind,source=...,target=...)
+// into the Engine API representation.
+func parseMount(spec string) (mount, error) {
+	m := mount{Type: "volume"}
+	for _, field := range strings.Split(spec, ",") {
+		key, value, hasValue := strings.Cut(strings.TrimSpace(field), "=")
+		switch strings.ToLower(key) {
+		case "type":
+			m.Type = value
+		case "source", "src":
+			m.Source = value
+		case "target", "destination", "dst":
+			m.Target = value
+		case "readonly", "ro":
+			readOnly := true
+			if hasValue {
+				var err error
+				readOnly, err = strconv.ParseBool(value)
+				if err != nil {
+					return mount{}, fmt.Errorf("invalid readonly value in mount %q", spec)
+				}
+			}
+			m.ReadOnly = readOnly
+		case "consistency":
+			m.Consistency = value
+		case "bind-propagation":
+			m.BindOptions = &bindOptions{Propagation: value}
+		case "volume-nocopy":
+			m.VolumeOptions = &volumeOptions{NoCopy: true}
+		case "tmpfs-size":
+			size, err := parseSize(value)
+			if err != nil {
+				return mount{}, fmt.Errorf("invalid tmpfs-size in mount %q: %w", spec, err)
+			}
+			if m.TmpfsOptions == nil {
+				m.TmpfsOptions = &tmpfsOptions{}
+			}
+			m.TmpfsOptions.SizeBytes = size
+		case "tmpfs-mode":
+			mode, err := strconv.ParseInt(value, 8, 32)
+			if err != nil {
+				return mount{}, fmt.Errorf("invalid tmpfs-mode in mount %q: %w", spec, err)
+			}
+			if m.TmpfsOptions == nil {
+				m.TmpfsOptions = &tmpfsOptions{}
+			}
+			m.TmpfsOptions.Mode = int(mode)
+		case "":
+			// ignore empty fields, e.g. from a trailing comma
+		default:
+			return mount{}, fmt.Errorf("unknown option %q in mount %q", key, spec)
+		}
+	}
+	if m.Target == "" {
+		return mount{}, fmt.Errorf("mount %q has no target", spec)
+	}
+	return m, nil
+}
+
+// runArgFlags lists the supported "docker run" flags and whether they take a value.
+var runArgFlags = map[string]bool{
+	"--privileged":   false,
+	"--init":         false,
+	"--rm":           false,
+	"--cap-add":      true,
+	"--cap-drop":     true,
+	"--security-opt": true,
+	"--network":      true,
+	"--net":          true,
+	"-e":             true,
+	"--env":          true,
+	"--device":       true,
+	"--add-host":     true,
+	"--shm-size":     true,
+	"-v":             true,
+	"--volume":       true,
+	"--mount":        true,
+	"-h":             true,
+	"--hostname":     true,
+	"-u":             true,
+	"--user":         true,
+	"-l":             true,
+	"--label":        true,
+	"--ipc":          true,
+	"--pid":          true,
+	"--userns":       true,
+	"--group-add":    true,
+	"-p":             true,
+	"--publish":      true,
+	"--gpus":         true,
+	"-m":             true,
+	"--memory":       true,
+	"--memory-swap":  true,
+	"--cpus":         true,
+	"--cpu-shares":   true,
+	"--ulimit":       true,
+	"--tmpfs":        true,
+	"--sysctl":       true,
+	"--dns":          true,
+	"--dns-search":   true,
+	"--dns-option":   true,
+	"--entrypoint":   true,
+	"-w":             true,
+	"--workdir":      true,
+	"--read-only":    false,
+}
+
+// applyRunArgs translates the "runArgs" of the devcontainer.json, which are given as
+// "docker run" command line flags, into the container create request. Unsupported flags are
+// an error, because dropping them would silently change the container.
+func applyRunArgs(config *containerCreateConfig, runArgs []string) error {
+	for i := 0; i < len(runArgs); i++ {
+		flag, value, hasValue := strings.Cut(runArgs[i], "=")
+		takesValue, known := runArgFlags[flag]
+		if !known {
+			return fmt.Errorf("unsupported runArg %s", runArgs[i])
+		}
+		if takesValue && !hasValue {
+			if i+1 >= len(runArgs) {
+				return fmt.Errorf("runArg %s needs a value", flag)
+			}
+			i++
+			value = runArgs[i]
+		}
+		if err := applyRunArg(config, flag, value); err != nil {
+			return fmt.Errorf("invalid runArg %s: %w", flag, err)
+		}
+	}
+	return nil
+}
+
+func applyRunArg(config *containerCreateConfig, flag string, value string) error {
+	hc := &config.HostConfig
+	switch flag {
+	case "--privileged":
+		hc.Privileged = true
+	case "--init":
+		init := true
+		hc.Init = &init
+	case "--rm":
+		// the container is kept to be reused, so auto removal is ignored
+	case "--cap-add":
+		hc.CapAdd = append(hc.CapAdd, value)
+	case "--cap-drop":
+		hc.CapDrop = append(hc.CapDrop, value)
+	case "--security-opt":
+		hc.SecurityOpt = append(hc.SecurityOpt, value)
+	case "--network", "--net":
+		hc.NetworkMode = value
+	case "-e", "--env":
+		config.Env = append(config.Env, value)
+	case "--device":
+		parts := strings.Split(value, ":")
+		device := deviceMapping{PathOnHost: parts[0], PathInContainer: parts[0], CgroupPermissions: "rwm"}
+		if len(parts) > 1 {
+			device.PathInContainer = parts[1]
+		}
+		if len(parts) > 2 {
+			device.CgroupPermissions = parts[2]
+		}
+		hc.Devices = append(hc.Devices, device)
+	case "--add-host":
+		hc.ExtraHosts = append(hc.ExtraHosts, value)
+	case "--shm-size":
+		size, err := parseSize(value)
+		if err != nil {
+			return err
+		}
+		hc.ShmSize = size
+	case "-v", "--volume":
+		hc.Binds = append(hc.Binds, value)
+	case "--mount":
+		m, err := parseMount(value)
+		if err != nil {
+			return err
+		}
+		hc.Mounts = append(hc.Mounts, m)
+	case "-h", "--hostname":
+		config.Hostname = value
+	case "-u", "--user":
+		config.User = value
+	case "-l", "--label":
+		key, labelValue, _ := strings.Cut(value, "=")
+		if config.Labels == nil {
+			config.Labels = map[string]string{}
+		}
+		config.Labels[key] = labelValue
+	case "--ipc":
+		hc.IpcMode = value
+	case "--pid":
+		hc.PidMode = value
+	case "--userns":
+		hc.UsernsMode = value
+	case "--group-add":
+		hc.GroupAdd = append(hc.GroupAdd, value)
+	case "-p", "--publish":
+		return applyPublish(config, value)
+	case "--gpus":
+		request := deviceRequest{Capabilities: [][]string{{"gpu"}}}
+		if value == "all" {
+			request.Count = -1
+		} else if count, err := strconv.Atoi(value); err == nil {
+			request.Count = count
+		} else {
+			request.DeviceIDs = strings.Split(strings.TrimPrefix(value, "device="), ",")
+		}
+		hc.DeviceRequests = append(hc.DeviceRequests, request)
+	case "-m", "--memory":
+		size, err := parseSize(value)
+		if err != nil {
+			return err
+		}
+		hc.Memory = size
+	case "--memory-swap":
+		if value == "-1" {
+			hc.MemorySwap = -1
+			return nil
+		}
+		size, err := parseSize(value)
+		if err != nil {
+			return err
+		}
+		hc.MemorySwap = size
+	case "--cpus":
+		cpus, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number of cpus %q", value)
+		}
+		hc.NanoCpus = int64(cpus * 1e9)
+	case "--cpu-shares":
+		shares, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid cpu shares %q", value)
+		}
+		hc.CpuShares = shares
+	case "--ulimit":
+		limit, err := parseUlimit(value)
+		if err != nil {
+			return err
+		}
+		hc.Ulimits = append(hc.Ulimits, limit)
+	case "--tmpfs":
+		path, options, _ := strings.Cut(value, ":")
+		if hc.Tmpfs == nil {
+			hc.Tmpfs = map[string]string{}
+		}
+		hc.Tmpfs[path] = options
+	case "--sysctl":
+		key, sysctlValue, found := strings.Cut(value, "=")
+		if !found {
+			return fmt.Errorf("invalid sysctl %q", value)
+		}
+		if hc.Sysctls == nil {
+			hc.Sysctls = map[string]string{}
+		}
+		hc.Sysctls[key] = sysctlValue
+	case "--dns":
+		hc.Dns = append(hc.Dns, value)
+	case "--dns-search":
+		hc.DnsSearch = append(hc.DnsSearch, value)
+	case "--dns-option":
+		hc.DnsOptions = append(hc.DnsOptions, value)
+	case "--entrypoint":
+		config.Entrypoint = []string{value}
+	case "-w", "--workdir":
+		config.WorkingDir = value
+	case "--read-only":
+		hc.ReadonlyRootfs = true
+	}
+	return nil
+}
+
+// parseUlimit parses a ulimit of the form name=soft[:hard].
+func parseUlimit(value string) (ulimit, error) {
+	name, limits, found := strings.Cut(value, "=")
+	if !found {
+		return ulimit{}, fmt.Errorf("invalid ulimit %q", value)
+	}
+	softValue, hardValue, hasHard := strings.Cut(limits, ":")
+	if !hasHard {
+		hardValue = softValue
+	}
+	soft, err := strconv.ParseInt(softValue, 10, 64)
+	if err != nil {
+		return ulimit{}, fmt.Errorf("invalid ulimit %q", value)
+	}
+	hard, err := strconv.ParseInt(hardValue, 10, 64)
+	if err != nil {
+		return ulimit{}, fmt.Errorf("invalid ulimit %q", value)
+	}
+	return ulimit{Name: name, Soft: soft, Hard: hard}, nil
+}
+
+// applyPublish parses a port mapping of the form [[hostIp:]hostPort:]containerPort[/protocol].
+func applyPublish(config *containerCreateConfig, value string) error {
+	port, protocol, found := strings.Cut(value, "/")
+	if !found {
+		protocol = "tcp"
+	}
+	parts := strings.Split(port, ":")
+	binding := portBinding{}
+	var containerPort string
+	switch len(parts) {
+	case 1:
+		containerPort = parts[0]
+	case 2:
+		binding.HostPort, containerPort = parts[0], parts[1]
+	case 3:
+		binding.HostIP, binding.HostPort, containerPort = parts[0], parts[1], parts[2]
+	default:
+		return fmt.Errorf("invalid port mapping %q", value)
+	}
+	if _, err := strconv.Atoi(containerPort); err != nil {
+		return fmt.Errorf("invalid container port in %q", value)
+	}
+	key := containerPort + "/" + protocol
+	if config.ExposedPorts == nil {
+		config.ExposedPorts = map[string]struct{}{}
+	}
+	if config.HostConfig.PortBindings == nil {
+		config.HostConfig.PortBindings = map[string][]portBinding{}
+	}
+	config.ExposedPorts[key] = struct{}{}
+	config.HostConfig.PortBindings[key] = append(config.HostConfig.PortBindings[key], binding)
+	return nil
+}
+
+// parseSize parses sizes like "512m" or "2g" into bytes.
+func parseSize(value string) (int64, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	multiplier := int64(1)
+	units := []struct {
+		suffix string
+		factor int64
+	}{
+		{"kb", 1 << 10}, {"mb", 1 << 20}, {"gb", 1 << 30},
+		{"k", 1 << 10}, {"m", 1 << 20}, {"g", 1 << 30}, {"b", 1},
+	}
+	for _, unit := range units {
+		if strings.HasSuffix(value, unit.suffix) {
+			multiplier = unit.factor
+			value = strings.TrimSuffix(value, unit.suffix)
+			break
+		}
+	}
+	size, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+	return size * multiplier, nil
+}
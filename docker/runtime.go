@@ -0,0 +1,158 @@
+package docker
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+
+	devcontainerspec "github.com/johndoe2991/devcli/devcontainer_spec"
+)
+
+const (
+	RuntimeDocker = "docker"
+	RuntimePodman = "podman"
+)
+
+// Runtime is the container engine used to build, run and clean devcontainers.
+type Runtime interface {
+	// Name returns the name of the runtime, e.g. "docker" or "podman".
+	Name() string
+	PullImage(image string) error
+	BuildImage(devc devcontainerspec.Devcontainer, opts BuildOptions) error
+	// BuildRemoteUserImage derives an image from the devcontainer image with the uid and gid
+	// of the remote user changed to the ones of the host user.
+	BuildRemoteUserImage(devc devcontainerspec.Devcontainer) error
+	ImageExists(imageName string) (bool, error)
+	RemoveImage(imageName string) error
+	// ListImages returns the images with the given labels, an empty value matches any value.
+	ListImages(labels map[string]string) ([]ImageInfo, error)
+	ContainerExists(containerName string) (bool, error)
+	ContainerRunning(containerName string) (bool, error)
+	// CreateAndStartContainer creates the container for the devcontainer and keeps it running.
+	CreateAndStartContainer(devc devcontainerspec.Devcontainer) error
+	StartContainer(containerName string) error
+	StopContainer(containerName string) error
+	RemoveContainer(containerName string) error
+	// ListContainers returns the containers with the given labels, an empty value matches any value.
+	ListContainers(labels map[string]string) ([]ContainerInfo, error)
+	ContainerState(containerName string) (ContainerState, error)
+	// ContainerEnv returns the environment the container was created with.
+	ContainerEnv(containerName string) (map[string]string, error)
+	// ContainerLogs returns the last lines of the container output.
+	ContainerLogs(containerName string, lines int) (string, error)
+	// ProbeExec runs a trivial command to check that the container accepts exec sessions.
+	ProbeExec(containerName string) error
+	ExecCommand(containerName string, opts ExecOptions) error
+	// ComposeUp starts the given services of a compose project, all services if none are given.
+	ComposeUp(project string, files []string, services []string) error
+	// ComposeBuild builds the images of a compose project.
+	ComposeBuild(project string, files []string, noCache bool) error
+	// ComposeStop stops the containers of a compose project without removing them.
+	ComposeStop(project string, files []string) error
+	// ComposeDown removes the containers and networks of a compose project.
+	ComposeDown(project string, files []string) error
+	// ComposeContainer returns the container of a compose service and whether it is running.
+	ComposeContainer(project string, service string) (string, bool, error)
+}
+
+// ExecOptions configures a command run inside the container.
+type ExecOptions struct {
+	// AttachStdin forwards the stdin of devcli to the command
+	AttachStdin bool
+	// Tty allocates a tty, the output of the command is then not split into stdout and stderr
+	Tty bool
+	// AsUser runs the command as User, or with the identity of the host user if User is empty
+	AsUser     bool
+	User       string
+	WorkingDir string
+	// Env is set in addition to the environment of the container
+	Env map[string]string
+	Cmd []string
+	// Stdout and Stderr default to the output of devcli
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// NewRuntime returns the runtime with the given name. An empty name selects docker.
+func NewRuntime(name string) (Runtime, error) {
+	switch name {
+	case "", RuntimeDocker:
+		return newDockerRuntime()
+	case RuntimePodman:
+		return newPodmanRuntime()
+	default:
+		return nil, fmt.Errorf("unknown container runtime %q, supported are %q and %q", name, RuntimeDocker, RuntimePodman)
+	}
+}
+
+// engineRuntime implements Runtime on top of the Docker Engine API, which is also
+// served by podman's compatibility socket. The runtimes only differ in how the host
+// user is mapped into the container.
+type engineRuntime struct {
+	name   string
+	client *client
+	// usernsMode is set as user namespace mode on container creation.
+	usernsMode string
+	// execUser returns the user for commands that run as the host user.
+	// An empty user leaves the choice to the container.
+	execUser func() (string, error)
+	// composeArgs is the command for compose projects, which are not part of the Engine API.
+	composeArgs []string
+}
+
+func (rt *engineRuntime) Name() string {
+	return rt.name
+}
+
+func newDockerRuntime() (*engineRuntime, error) {
+	c, err := newClient(os.Getenv("DOCKER_HOST"))
+	if err != nil {
+		return nil, err
+	}
+	return &engineRuntime{
+		name:        RuntimeDocker,
+		client:      c,
+		execUser:    hostUser,
+		composeArgs: []string{"docker", "compose"},
+	}, nil
+}
+
+// newPodmanRuntime connects to the podman API socket. Podman maps the host user into
+// the container with "keep-id", so commands need no explicit user.
+func newPodmanRuntime() (*engineRuntime, error) {
+	c, err := newClient(podmanHost())
+	if err != nil {
+		return nil, err
+	}
+	return &engineRuntime{
+		name:       RuntimePodman,
+		client:     c,
+		usernsMode: "keep-id",
+		execUser: func() (string, error) {
+			return "", nil
+		},
+		composeArgs: []string{"podman", "compose"},
+	}, nil
+}
+
+// podmanHost returns the podman API socket, either from CONTAINER_HOST or the default
+// rootless or rootful socket. The socket is provided by "systemctl --user start podman.socket".
+func podmanHost() string {
+	if host := os.Getenv("CONTAINER_HOST"); host != "" {
+		return host
+	}
+	if runtimeDir := os.Getenv("XDG_RUNTIME_DIR"); runtimeDir != "" && os.Getuid() != 0 {
+		return "unix://" + filepath.Join(runtimeDir, "podman", "podman.sock")
+	}
+	return "unix:///run/podman/podman.sock"
+}
+
+func hostUser() (string, error) {
+	currentUser, err := user.Current()
+	if err != nil {
+		return "", err
+	}
+	return currentUser.Uid + ":" + currentUser.Gid, nil
+}
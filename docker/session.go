@@ -0,0 +1,134 @@
+package docker
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+
+	devcontainerspec "github.com/johndoe2991/devcli/devcontainer_spec"
+)
+
+// session marks an interactive devcli session of a container on the host. Every session holds
+// a lock on its own file, which the kernel releases even if devcli is killed, so files without
+// lock belong to sessions that ended.
+type session struct {
+	file *os.File
+	name string
+}
+
+// sessionName names the sessions of a devcontainer like its container, or like its project for
+// compose devcontainers.
+func sessionName(devc devcontainerspec.Devcontainer) string {
+	if devc.IsCompose() {
+		return devc.GetComposeProject()
+	}
+	return devc.GetContainerName()
+}
+
+// sessionDir returns the directory of the sessions with the given name.
+func sessionDir(name string) (string, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(cacheDir, "devcli", "sessions", name), nil
+}
+
+func startSession(name string) (*session, error) {
+	dir, err := sessionDir(name)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	// the file is locked before it gets its name, so other sessions never see it unlocked
+	f, err := os.CreateTemp(dir, ".tmp-")
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	file := filepath.Join(dir, strconv.Itoa(os.Getpid()))
+	if err := os.Rename(f.Name(), file); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return &session{file: f, name: file}, nil
+}
+
+// end releases the session and reports whether it was the last active session of the container.
+// A nil session was never registered and is never the last one.
+func (s *session) end() (bool, error) {
+	if s == nil {
+		return false, nil
+	}
+	dir := filepath.Dir(s.name)
+	os.Remove(s.name)
+	s.file.Close()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return false, err
+	}
+	last := true
+	for _, entry := range entries {
+		active, err := sessionActive(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return false, err
+		}
+		last = last && !active
+	}
+	return last, nil
+}
+
+// sessionActive reports whether the session file is locked, files of ended sessions are removed.
+func sessionActive(file string) (bool, error) {
+	f, err := os.Open(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	os.Remove(file)
+	return false, nil
+}
+
+// shutdown applies the "shutdownAction" after the last session of the container ended.
+func shutdown(rt Runtime, devc devcontainerspec.Devcontainer, containerName string) error {
+	switch devc.Config.ShutdownAction {
+	case devcontainerspec.ShutdownStopContainer:
+		logger.Info().Str("container", containerName).Msg("last session ended, stopping container")
+		return rt.StopContainer(containerName)
+	case devcontainerspec.ShutdownStopCompose:
+		logger.Info().Str("project", devc.GetComposeProject()).Msg("last session ended, stopping compose project")
+		return rt.ComposeStop(devc.GetComposeProject(), devc.Config.ComposeFiles)
+	}
+	return nil
+}
+
+// Stop stops the container of the devcontainer, or all services of a compose devcontainer.
+func Stop(rt Runtime, devc devcontainerspec.Devcontainer) error {
+	if devc.IsCompose() {
+		return rt.ComposeStop(devc.GetComposeProject(), devc.Config.ComposeFiles)
+	}
+	running, err := rt.ContainerRunning(devc.GetContainerName())
+	if err != nil || !running {
+		return err
+	}
+	return rt.StopContainer(devc.GetContainerName())
+}
@@ -0,0 +1,45 @@
+package docker
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestSession(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	dir, err := sessionDir("devc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sess, err := startSession("devc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != strconv.Itoa(os.Getpid()) {
+		t.Fatalf("unexpected session files %v", entries)
+	}
+	active, err := sessionActive(filepath.Join(dir, entries[0].Name()))
+	if err != nil || !active {
+		t.Fatalf("session is not locked: %v", err)
+	}
+	// a session file without lock belongs to a session that was killed
+	if err := os.WriteFile(filepath.Join(dir, "1"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	last, err := sess.end()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !last {
+		t.Error("ended session is not the last one")
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("session files are left behind: %v", entries)
+	}
+}
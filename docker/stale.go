@@ -0,0 +1,82 @@
+package docker
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	devcontainerspec "github.com/johndoe2991/devcli/devcontainer_spec"
+	"golang.org/x/term"
+)
+
+// NotifyStale reports containers of older configs of the workspace. In a terminal it offers
+// to delete them together with their images.
+func NotifyStale(rt Runtime, devc devcontainerspec.Devcontainer) error {
+	stale, err := StaleContainers(rt, devc)
+	if err != nil || len(stale) == 0 {
+		return err
+	}
+	names := []string{}
+	for _, container := range stale {
+		names = append(names, container.Name)
+	}
+	logger.Warn().Strs("containers", names).Msg("found containers of older configs of this workspace")
+	// a dry run only reports them, deleting them is up to the real run
+	if isDryRun(rt) || !term.IsTerminal(int(os.Stdin.Fd())) {
+		return nil
+	}
+	// the prompt goes to stderr, so stdout of the command stays clean
+	fmt.Fprintf(os.Stderr, "Delete %d stale container(s) and their images? [y/N] ", len(stale))
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return nil
+	}
+	if answer = strings.ToLower(strings.TrimSpace(answer)); answer != "y" && answer != "yes" {
+		return nil
+	}
+	return CleanStale(rt, devc, stale)
+}
+
+// StaleContainers returns the containers of older versions of the config of the workspace,
+// which are left behind when the config changes. Other configs of the workspace are not stale.
+func StaleContainers(rt Runtime, devc devcontainerspec.Devcontainer) ([]ContainerInfo, error) {
+	containers, err := rt.ListContainers(workspaceLabels(devc))
+	if err != nil {
+		return nil, err
+	}
+	stale := []ContainerInfo{}
+	for _, container := range containers {
+		// the compose project of the config is updated by compose itself
+		if devc.IsCompose() && container.Labels[composeProjectLabel] == devc.GetComposeProject() {
+			continue
+		}
+		if sameConfig(container.Labels, devc) && container.Labels[LabelHash] != devc.Hash {
+			stale = append(stale, container)
+		}
+	}
+	return stale, nil
+}
+
+// CleanStale deletes the given stale containers and all images of older versions of the config.
+// Stale compose devcontainers are deleted with their compose project.
+func CleanStale(rt Runtime, devc devcontainerspec.Devcontainer, containers []ContainerInfo) error {
+	for _, container := range containers {
+		if err := cleanListedContainer(rt, container); err != nil {
+			return err
+		}
+	}
+	images, err := rt.ListImages(workspaceLabels(devc))
+	if err != nil {
+		return err
+	}
+	for _, image := range images {
+		if !sameConfig(image.Labels, devc) || image.Labels[LabelHash] == devc.Hash {
+			continue
+		}
+		if err := CleanImage(rt, image.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
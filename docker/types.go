@@ -0,0 +1,164 @@
+package docker
+
+import "time"
+
+// Request and response bodies of the Docker Engine API. Only the fields used by devcli are declared.
+
+type containerCreateConfig struct {
+	Image        string              `json:"Image"`
+	Cmd          []string            `json:"Cmd,omitempty"`
+	Env          []string            `json:"Env,omitempty"`
+	User         string              `json:"User,omitempty"`
+	Hostname     string              `json:"Hostname,omitempty"`
+	WorkingDir   string              `json:"WorkingDir,omitempty"`
+	Entrypoint   []string            `json:"Entrypoint,omitempty"`
+	Labels       map[string]string   `json:"Labels,omitempty"`
+	ExposedPorts map[string]struct{} `json:"ExposedPorts,omitempty"`
+	HostConfig   hostConfig          `json:"HostConfig"`
+}
+
+type hostConfig struct {
+	Binds          []string                 `json:"Binds,omitempty"`
+	Mounts         []mount                  `json:"Mounts,omitempty"`
+	Privileged     bool                     `json:"Privileged,omitempty"`
+	Init           *bool                    `json:"Init,omitempty"`
+	CapAdd         []string                 `json:"CapAdd,omitempty"`
+	CapDrop        []string                 `json:"CapDrop,omitempty"`
+	SecurityOpt    []string                 `json:"SecurityOpt,omitempty"`
+	NetworkMode    string                   `json:"NetworkMode,omitempty"`
+	IpcMode        string                   `json:"IpcMode,omitempty"`
+	PidMode        string                   `json:"PidMode,omitempty"`
+	UsernsMode     string                   `json:"UsernsMode,omitempty"`
+	GroupAdd       []string                 `json:"GroupAdd,omitempty"`
+	ExtraHosts     []string                 `json:"ExtraHosts,omitempty"`
+	ShmSize        int64                    `json:"ShmSize,omitempty"`
+	Devices        []deviceMapping          `json:"Devices,omitempty"`
+	DeviceRequests []deviceRequest          `json:"DeviceRequests,omitempty"`
+	PortBindings   map[string][]portBinding `json:"PortBindings,omitempty"`
+	Memory         int64                    `json:"Memory,omitempty"`
+	MemorySwap     int64                    `json:"MemorySwap,omitempty"`
+	NanoCpus       int64                    `json:"NanoCpus,omitempty"`
+	CpuShares      int64                    `json:"CpuShares,omitempty"`
+	Ulimits        []ulimit                 `json:"Ulimits,omitempty"`
+	Tmpfs          map[string]string        `json:"Tmpfs,omitempty"`
+	Sysctls        map[string]string        `json:"Sysctls,omitempty"`
+	Dns            []string                 `json:"Dns,omitempty"`
+	DnsSearch      []string                 `json:"DnsSearch,omitempty"`
+	DnsOptions     []string                 `json:"DnsOptions,omitempty"`
+	ReadonlyRootfs bool                     `json:"ReadonlyRootfs,omitempty"`
+}
+
+type ulimit struct {
+	Name string `json:"Name"`
+	Soft int64  `json:"Soft"`
+	Hard int64  `json:"Hard"`
+}
+
+type mount struct {
+	Type          string         `json:"Type"`
+	Source        string         `json:"Source,omitempty"`
+	Target        string         `json:"Target"`
+	ReadOnly      bool           `json:"ReadOnly,omitempty"`
+	Consistency   string         `json:"Consistency,omitempty"`
+	BindOptions   *bindOptions   `json:"BindOptions,omitempty"`
+	VolumeOptions *volumeOptions `json:"VolumeOptions,omitempty"`
+	TmpfsOptions  *tmpfsOptions  `json:"TmpfsOptions,omitempty"`
+}
+
+type bindOptions struct {
+	Propagation string `json:"Propagation,omitempty"`
+}
+
+type volumeOptions struct {
+	NoCopy bool `json:"NoCopy,omitempty"`
+}
+
+type tmpfsOptions struct {
+	SizeBytes int64 `json:"SizeBytes,omitempty"`
+	Mode      int   `json:"Mode,omitempty"`
+}
+
+type deviceMapping struct {
+	PathOnHost        string `json:"PathOnHost"`
+	PathInContainer   string `json:"PathInContainer"`
+	CgroupPermissions string `json:"CgroupPermissions"`
+}
+
+type deviceRequest struct {
+	Driver       string     `json:"Driver,omitempty"`
+	Count        int        `json:"Count,omitempty"`
+	DeviceIDs    []string   `json:"DeviceIDs,omitempty"`
+	Capabilities [][]string `json:"Capabilities,omitempty"`
+}
+
+type portBinding struct {
+	HostIP   string `json:"HostIp,omitempty"`
+	HostPort string `json:"HostPort,omitempty"`
+}
+
+type containerCreateResponse struct {
+	ID       string   `json:"Id"`
+	Warnings []string `json:"Warnings"`
+}
+
+type containerInspect struct {
+	ID     string `json:"Id"`
+	Name   string `json:"Name"`
+	Config struct {
+		Env []string `json:"Env"`
+	} `json:"Config"`
+	State struct {
+		Status     string    `json:"Status"`
+		Running    bool      `json:"Running"`
+		ExitCode   int       `json:"ExitCode"`
+		Error      string    `json:"Error"`
+		StartedAt  time.Time `json:"StartedAt"`
+		FinishedAt time.Time `json:"FinishedAt"`
+	} `json:"State"`
+}
+
+type containerSummary struct {
+	ID         string            `json:"Id"`
+	Names      []string          `json:"Names"`
+	Image      string            `json:"Image"`
+	State      string            `json:"State"`
+	Created    int64             `json:"Created"`
+	SizeRootFs int64             `json:"SizeRootFs"`
+	Labels     map[string]string `json:"Labels"`
+}
+
+type imageSummary struct {
+	ID       string            `json:"Id"`
+	RepoTags []string          `json:"RepoTags"`
+	Created  int64             `json:"Created"`
+	Size     int64             `json:"Size"`
+	Labels   map[string]string `json:"Labels"`
+}
+
+type imageInspect struct {
+	ID     string `json:"Id"`
+	Config struct {
+		User string `json:"User"`
+	} `json:"Config"`
+}
+
+type execCreateConfig struct {
+	AttachStdin  bool     `json:"AttachStdin"`
+	AttachStdout bool     `json:"AttachStdout"`
+	AttachStderr bool     `json:"AttachStderr"`
+	Tty          bool     `json:"Tty"`
+	User         string   `json:"User,omitempty"`
+	WorkingDir   string   `json:"WorkingDir,omitempty"`
+	Env          []string `json:"Env,omitempty"`
+	Cmd          []string `json:"Cmd"`
+}
+
+type execStartConfig struct {
+	Detach bool `json:"Detach"`
+	Tty    bool `json:"Tty"`
+}
+
+type execInspect struct {
+	Running  bool `json:"Running"`
+	ExitCode int  `json:"ExitCode"`
+}
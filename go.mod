@@ -2,10 +2,13 @@ module github.com/johndoe2991/devcli
 
 go 1.24.2
 
-require github.com/rs/zerolog v1.34.0
+require (
+	github.com/alexflint/go-arg v1.5.1
+	github.com/rs/zerolog v1.34.0
+	golang.org/x/term v0.12.0
+)
 
 require (
-	github.com/alexflint/go-arg v1.5.1 // indirect
 	github.com/alexflint/go-scalar v1.2.0 // indirect
 	github.com/mattn/go-colorable v0.1.13 // indirect
 	github.com/mattn/go-isatty v0.0.19 // indirect
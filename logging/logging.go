@@ -1,6 +1,7 @@
 package logging
 
 import (
+	"io"
 	"os"
 
 	"github.com/rs/zerolog"
@@ -15,13 +16,29 @@ var logger zerolog.Logger
 var loggerInit = false
 var writeToFile = false
 
+// output receives the console log. The loggers of the packages are created before the
+// arguments are parsed, so they write to it through consoleOutput.
+var output io.Writer = os.Stdout
+
+type consoleOutput struct{}
+
+func (consoleOutput) Write(p []byte) (int, error) {
+	return output.Write(p)
+}
+
 func WriteToLogFile(_writeToFile bool) {
 	writeToFile = _writeToFile
 }
 
+// SetOutput changes where the console log is written to, e.g. to os.Stderr to keep stdout
+// free for the output of a command.
+func SetOutput(w io.Writer) {
+	output = w
+}
+
 func initLog() {
 	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
-	consoleWriter := zerolog.ConsoleWriter{Out: os.Stdout, TimeFormat: "15:04:05", NoColor: false}
+	consoleWriter := zerolog.ConsoleWriter{Out: consoleOutput{}, TimeFormat: "15:04:05", NoColor: false}
 	if writeToFile {
 		runLogFile, err := os.OpenFile(
 			filename,
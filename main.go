@@ -1,12 +1,19 @@
 package main
 
 import (
+	"bufio"
+	"errors"
+	"fmt"
 	"os"
+	"strconv"
+	"strings"
 
 	"github.com/alexflint/go-arg"
 	devcontainerspec "github.com/johndoe2991/devcli/devcontainer_spec"
 	"github.com/johndoe2991/devcli/docker"
 	"github.com/johndoe2991/devcli/logging"
+	"github.com/rs/zerolog"
+	"golang.org/x/term"
 )
 
 type CleanCmd struct {
@@ -14,10 +21,36 @@ type CleanCmd struct {
 	Global bool `arg:"--global" help:"delete all devcontainers and images created by devcli"`
 }
 
+type RebuildCmd struct {
+	NoCache bool `arg:"--no-cache" help:"build the image without the layer cache"`
+}
+
+type StopCmd struct{}
+
+type ExecCmd struct {
+	Command []string `arg:"positional,required" help:"command to run in the devcontainer, separate it from the devcli flags with --"`
+}
+
+type ConfigCmd struct {
+	Source bool `arg:"--source" help:"annotate every value with the files that set it"`
+}
+
+type ListCmd struct {
+	JSON bool `arg:"--json" help:"print the list as JSON"`
+}
+
 type Args struct {
-	Debug bool      `arg:"-d,--debug" help:"activate debug outputs"`
-	Logs  bool      `arg:"-l, --log" help:"create a log file in the current directory with all outputs"`
-	Clean *CleanCmd `arg:"subcommand:clean" help:"delete image and container"`
+	Debug      bool        `arg:"-d,--debug" help:"activate debug outputs"`
+	Logs       bool        `arg:"-l, --log" help:"create a log file in the current directory with all outputs"`
+	Runtime    string      `arg:"--runtime,env:DEVCLI_RUNTIME" help:"container runtime to use: docker or podman [default: customizations.devcli.runtime or docker]"`
+	DryRun     bool        `arg:"--dry-run" help:"print the container runtime commands instead of running them"`
+	ConfigName string      `arg:"--config,env:DEVCLI_CONFIG" help:"name of the config in .devcontainer/<name>/ or path of a devcontainer.json"`
+	Clean      *CleanCmd   `arg:"subcommand:clean" help:"delete image and container"`
+	Rebuild    *RebuildCmd `arg:"subcommand:rebuild" help:"delete image and container of the current config and build them again"`
+	List       *ListCmd    `arg:"subcommand:list|ls|status" help:"list all devcontainers and images created by devcli"`
+	Stop       *StopCmd    `arg:"subcommand:stop" help:"stop the container of the current config"`
+	Exec       *ExecCmd    `arg:"subcommand:exec" help:"run a command in the devcontainer"`
+	Config     *ConfigCmd  `arg:"subcommand:config" help:"print the effective config with hash, image and container name as JSON"`
 }
 
 func (Args) Version() string {
@@ -34,10 +67,19 @@ func main() {
 	}
 	if args.Debug {
 		logging.SetLevelFromString("debug")
+	} else if (args.List != nil && args.List.JSON) || args.Config != nil {
+		// keep the JSON output parseable
+		logging.SetLevelFromString("error")
+	}
+	if args.Exec != nil || args.DryRun {
+		// stdout only carries the output of the command or the printed commands, so it can be piped
+		logging.SetOutput(os.Stderr)
+		docker.SetOutput(os.Stderr)
 	}
 	logger := logging.GetLogger("main")
 
 	logger.Debug().Msgf("version: %s", version)
+	docker.DevcliVersion = version
 
 	cwd, err := os.Getwd()
 	if err != nil {
@@ -49,48 +91,242 @@ func main() {
 	default:
 		// default command without anything; start devcontainer
 		// get devcontainer setup
-		devc, err := devcontainerspec.ParseDevcontainer(cwd)
+		devc, err := parseDevcontainer(cwd, args.ConfigName)
 		if err != nil {
 			logger.Fatal().Err(err).Msg("could not get devcontainer setup")
 		}
-		err = docker.Run(devc)
+		rt, err := newRuntime(args, devc)
+		if err != nil {
+			logger.Fatal().Err(err).Msg("could not connect to container runtime")
+		}
+		err = docker.NotifyStale(rt, devc)
+		if err != nil {
+			// the notice must not keep the devcontainer from starting
+			logger.Warn().Err(err).Msg("could not check for stale containers")
+		}
+		err = docker.Run(rt, devc, docker.BuildOptions{})
+		exitOnError(logger, err, "could not run devcontainer")
+	case args.Rebuild != nil:
+		devc, err := parseDevcontainer(cwd, args.ConfigName)
+		if err != nil {
+			logger.Fatal().Err(err).Msg("could not get devcontainer setup")
+		}
+		rt, err := newRuntime(args, devc)
+		if err != nil {
+			logger.Fatal().Err(err).Msg("could not connect to container runtime")
+		}
+		opts := docker.BuildOptions{NoCache: args.Rebuild.NoCache}
+		err = docker.Rebuild(rt, devc, opts)
+		exitOnError(logger, err, "could not rebuild devcontainer")
+		err = docker.Run(rt, devc, opts)
+		exitOnError(logger, err, "could not run devcontainer")
+	case args.Exec != nil:
+		devc, err := parseDevcontainer(cwd, args.ConfigName)
+		if err != nil {
+			logger.Fatal().Err(err).Msg("could not get devcontainer setup")
+		}
+		rt, err := newRuntime(args, devc)
+		if err != nil {
+			logger.Fatal().Err(err).Msg("could not connect to container runtime")
+		}
+		err = docker.Exec(rt, devc, args.Exec.Command)
+		exitOnError(logger, err, "could not run command in devcontainer")
+	case args.Stop != nil:
+		devc, err := parseDevcontainer(cwd, args.ConfigName)
+		if err != nil {
+			logger.Fatal().Err(err).Msg("could not get devcontainer setup")
+		}
+		rt, err := newRuntime(args, devc)
+		if err != nil {
+			logger.Fatal().Err(err).Msg("could not connect to container runtime")
+		}
+		err = docker.Stop(rt, devc)
+		if err != nil {
+			logger.Fatal().Err(err).Str("container", devc.GetContainerName()).Msg("could not stop devcontainer")
+		}
+	case args.Config != nil:
+		devc, err := parseDevcontainer(cwd, args.ConfigName)
+		if err != nil {
+			logger.Fatal().Err(err).Msg("could not get devcontainer setup")
+		}
+		err = devcontainerspec.PrintConfig(os.Stdout, devc, args.Config.Source)
+		if err != nil {
+			logger.Fatal().Err(err).Msg("could not print config")
+		}
+	case args.List != nil:
+		// the list works without a devcontainer setup, so the project config is optional here
+		devc, _ := findDevcontainer(cwd, args.ConfigName)
+		rt, err := newRuntime(args, devc)
+		if err != nil {
+			logger.Fatal().Err(err).Msg("could not connect to container runtime")
+		}
+		entries, err := docker.List(rt, func(workspace string, name string) (string, bool) {
+			if _, err := os.Stat(workspace); os.IsNotExist(err) {
+				// everything of a deleted workspace is stale
+				return "", true
+			}
+			configs, err := devcontainerspec.FindConfigs(workspace)
+			if err != nil {
+				logger.Debug().Err(err).Str("workspace", workspace).Msg("could not find configs of workspace")
+				return "", false
+			}
+			for _, config := range configs {
+				if config.Name != name {
+					continue
+				}
+				current, err := devcontainerspec.ParseDevcontainer(workspace, config)
+				if err != nil {
+					logger.Debug().Err(err).Str("workspace", workspace).Str("config", name).Msg("could not get current config of workspace")
+					return "", false
+				}
+				return current.Hash, true
+			}
+			// everything of a deleted config is stale
+			return "", true
+		})
+		if err != nil {
+			logger.Fatal().Err(err).Msg("could not list devcontainers")
+		}
+		err = docker.PrintList(os.Stdout, entries, args.List.JSON)
 		if err != nil {
-			logger.Fatal().Err(err).Msg("could not run devcontainer")
+			logger.Fatal().Err(err).Msg("could not print list")
 		}
 	case args.Clean != nil:
 		if args.Clean.Global {
-			err := docker.CleanAllContainers()
+			// the global clean works without a devcontainer setup, so the project config is optional here
+			devc, _ := findDevcontainer(cwd, args.ConfigName)
+			rt, err := newRuntime(args, devc)
+			if err != nil {
+				logger.Fatal().Err(err).Msg("could not connect to container runtime")
+			}
+			err = docker.CleanAllContainers(rt)
 			if err != nil {
 				logger.Fatal().Err(err).Msg("could not clean all containers")
 			}
-			err = docker.CleanAllImages()
+			err = docker.CleanAllImages(rt)
 			if err != nil {
 				logger.Fatal().Err(err).Msg("could not clean all images")
 			}
 			return
 		}
-		devc, err := devcontainerspec.ParseDevcontainer(cwd)
+		devc, err := parseDevcontainer(cwd, args.ConfigName)
 		if err != nil {
 			logger.Fatal().Err(err).Msg("could not get devcontainer setup")
 		}
+		rt, err := newRuntime(args, devc)
+		if err != nil {
+			logger.Fatal().Err(err).Msg("could not connect to container runtime")
+		}
+		if devc.IsCompose() {
+			err := docker.CleanCompose(rt, devc)
+			if err != nil {
+				logger.Fatal().Err(err).Str("project", devc.GetComposeProject()).Msg("could not delete compose project")
+			}
+		}
 		if args.Clean.All {
-			err := docker.CleanAllContainerVersions(devc)
+			err := docker.CleanAllContainerVersions(rt, devc)
 			if err != nil {
-				logger.Fatal().Err(err).Str("basename", devc.GetDevcNamePrefix()).Msg("could not delete all containers for this working directory")
+				logger.Fatal().Err(err).Str("workspace", devc.Cwd).Msg("could not delete all containers for this working directory")
 			}
-			err = docker.CleanAllImageVersions(devc)
+			err = docker.CleanAllImageVersions(rt, devc)
 			if err != nil {
-				logger.Fatal().Err(err).Str("basename", devc.GetDevcNamePrefix()).Msg("could not delete all images for this working directory")
+				logger.Fatal().Err(err).Str("workspace", devc.Cwd).Msg("could not delete all images for this working directory")
 			}
 		} else {
-			err := docker.CleanContainer(devc.GetContainerName())
+			err := docker.CleanContainer(rt, devc.GetContainerName())
 			if err != nil {
 				logger.Fatal().Err(err).Str("container name", devc.GetContainerName()).Msg("could not delete container")
 			}
-			err = docker.CleanImage(devc.GetImageName())
+			err = docker.CleanConfigImages(rt, devc)
 			if err != nil {
 				logger.Fatal().Err(err).Str("image name", devc.GetImageName()).Msg("could not delete image")
 			}
 		}
 	}
 }
+
+// parseDevcontainer parses the config of the workspace selected by the --config flag. If the
+// workspace has several configs without a default, the config is picked interactively.
+func parseDevcontainer(cwd string, selector string) (devcontainerspec.Devcontainer, error) {
+	config, err := devcontainerspec.SelectConfig(cwd, selector)
+	var ambiguous *devcontainerspec.AmbiguousConfigError
+	if errors.As(err, &ambiguous) && term.IsTerminal(int(os.Stdin.Fd())) {
+		config, err = pickConfig(ambiguous.Configs)
+	}
+	if err != nil {
+		return devcontainerspec.Devcontainer{}, err
+	}
+	return devcontainerspec.ParseDevcontainer(cwd, config)
+}
+
+// findDevcontainer parses the selected config of the workspace without asking, for commands
+// that work without a devcontainer setup.
+func findDevcontainer(cwd string, selector string) (devcontainerspec.Devcontainer, error) {
+	config, err := devcontainerspec.SelectConfig(cwd, selector)
+	if err != nil {
+		return devcontainerspec.Devcontainer{}, err
+	}
+	return devcontainerspec.ParseDevcontainer(cwd, config)
+}
+
+// pickConfig asks for one of the configs in the terminal.
+func pickConfig(configs []devcontainerspec.WorkspaceConfig) (devcontainerspec.WorkspaceConfig, error) {
+	// the prompt goes to stderr, so it does not mix with the output of the command
+	fmt.Fprintln(os.Stderr, "Found several devcontainer configs:")
+	for i, config := range configs {
+		fmt.Fprintf(os.Stderr, "  %d) %s (%s)\n", i+1, config.Name, config.Path)
+	}
+	fmt.Fprintf(os.Stderr, "Select a config [1-%d]: ", len(configs))
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return devcontainerspec.WorkspaceConfig{}, err
+	}
+	selected, err := strconv.Atoi(strings.TrimSpace(answer))
+	if err != nil || selected < 1 || selected > len(configs) {
+		return devcontainerspec.WorkspaceConfig{}, fmt.Errorf("invalid selection %q", strings.TrimSpace(answer))
+	}
+	return configs[selected-1], nil
+}
+
+// newRuntime selects the container runtime. The command line flag or DEVCLI_RUNTIME
+// take precedence over the runtime configured in the devcontainer.json.
+func newRuntime(args Args, devc devcontainerspec.Devcontainer) (docker.Runtime, error) {
+	name := args.Runtime
+	if name == "" {
+		name = devc.Config.Runtime
+	}
+	if args.DryRun {
+		if devc.Hash != "" {
+			fmt.Fprintf(os.Stderr, "# workspace %s, config %s, config hash %s\n", devc.Cwd, devc.ConfigPath, devc.Hash)
+		}
+		return docker.NewDryRunRuntime(name)
+	}
+	return docker.NewRuntime(name)
+}
+
+// exitOnError exits devcli if err is set. The exit code of a command in the container is passed
+// on without a message, because the command already reported its failure. Failed lifecycle
+// commands and failures of devcli or the container runtime are logged.
+func exitOnError(logger zerolog.Logger, err error, msg string) {
+	if err == nil {
+		return
+	}
+	var lifecycleErr *docker.LifecycleError
+	var exitErr *docker.ExitError
+	var runtimeErr *docker.RuntimeError
+	exitCode := 1
+	switch {
+	case errors.As(err, &lifecycleErr):
+		logger.Error().Err(lifecycleErr.Err).Str("stage", lifecycleErr.Stage).Msg("lifecycle command failed")
+		if errors.As(err, &exitErr) {
+			exitCode = exitErr.ExitCode
+		}
+	case errors.As(err, &exitErr):
+		exitCode = exitErr.ExitCode
+	case errors.As(err, &runtimeErr):
+		logger.Error().Err(runtimeErr.Err).Msg(msg + ": the container runtime failed")
+	default:
+		logger.Error().Err(err).Msg(msg)
+	}
+	os.Exit(exitCode)
+}